@@ -4,9 +4,19 @@ go 1.24.3
 
 require (
 	github.com/a-h/templ v0.3.887
+	github.com/nats-io/nats-server/v2 v2.11.4
 	github.com/nats-io/nats.go v1.42.0
 )
 
+require (
+	github.com/google/go-tpm v0.9.5 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/minio/highwayhash v1.0.3 // indirect
+	github.com/nats-io/jwt/v2 v2.7.4 // indirect
+	golang.org/x/time v0.11.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+)
+
 require (
 	github.com/a-h/parse v0.0.0-20250122154542-74294addb73e // indirect
 	github.com/andybalholm/brotli v1.1.0 // indirect
@@ -47,14 +57,13 @@ require (
 )
 
 require (
-	github.com/asaskevich/EventBus v0.0.0-20200907212545-49d423059eef
 	github.com/bmatcuk/doublestar/v4 v4.8.1 // indirect
 	github.com/casbin/casbin/v2 v2.105.0
 	github.com/casbin/govaluate v1.7.0 // indirect
 	github.com/casbin/redis-adapter/v2 v2.4.0
 	github.com/gin-gonic/gin v1.10.0
 	github.com/klauspost/compress v1.18.0 // indirect
-	github.com/nats-io/nkeys v0.4.11 // indirect
+	github.com/nats-io/nkeys v0.4.11
 	github.com/nats-io/nuid v1.0.1 // indirect
 	golang.org/x/crypto v0.38.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
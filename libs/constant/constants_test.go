@@ -43,3 +43,9 @@ func TestPlayerOnlineConstant(t *testing.T) {
 // 		seen[k] = struct{}{}
 // 	}
 // }
+
+func TestAdmissionHookSubject(t *testing.T) {
+	if got := AdmissionHookSubject("players", "permission"); got != "stellaroot.admission.players.permission" {
+		t.Fatalf("unexpected subject: %s", got)
+	}
+}
@@ -14,6 +14,24 @@ type AnnotationDescriptor[T any] struct {
 	Format func(T) string
 }
 
+// AnnotationValidator is the value-type independent view of an
+// AnnotationDescriptor, so descriptors of different types can be kept
+// together, e.g. to validate annotations before they are written.
+type AnnotationValidator interface {
+	AnnotationKey() AnnotationKey
+	// Validate returns the error Parse returns for value, if any.
+	Validate(value string) error
+}
+
+// AnnotationKey returns d.Key.
+func (d AnnotationDescriptor[T]) AnnotationKey() AnnotationKey { return d.Key }
+
+// Validate reports whether value parses.
+func (d AnnotationDescriptor[T]) Validate(value string) error {
+	_, err := d.Parse(value)
+	return err
+}
+
 // NewStringAnnotationDesc creates a descriptor that leaves values unchanged.
 func NewStringAnnotationDesc(key AnnotationKey) AnnotationDescriptor[string] {
 	return AnnotationDescriptor[string]{
@@ -0,0 +1,365 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// defaultAdmissionTimeout bounds a hook call when AdmissionHook.Timeout is not set.
+const defaultAdmissionTimeout = 2 * time.Second
+
+// AdmissionHookType tells whether a hook may change the objects it is sent.
+type AdmissionHookType int
+
+const (
+	// ValidatingHook allows or denies a write.
+	ValidatingHook AdmissionHookType = iota
+	// MutatingHook may also return a patch applied to the object before it
+	// is written. Mutating hooks run before validating ones.
+	MutatingHook
+)
+
+func (t AdmissionHookType) String() string {
+	switch t {
+	case ValidatingHook:
+		return "validating"
+	case MutatingHook:
+		return "mutating"
+	default:
+		return "unknown"
+	}
+}
+
+// FailurePolicy decides what happens to a write when its hook cannot be
+// reached, times out or answers with garbage.
+type FailurePolicy int
+
+const (
+	// FailClosed rejects the write.
+	FailClosed FailurePolicy = iota
+	// FailOpen logs the failure and goes on as if the hook had allowed it.
+	FailOpen
+)
+
+func (p FailurePolicy) String() string {
+	switch p {
+	case FailClosed:
+		return "fail-closed"
+	case FailOpen:
+		return "fail-open"
+	default:
+		return "unknown"
+	}
+}
+
+// AdmissionHook is a service consulted before every write of the kinds it
+// covers. It is called on constant.AdmissionHookSubject(kind, Name).
+type AdmissionHook struct {
+	Name string
+	Type AdmissionHookType
+	// Kinds limits the hook to these resource kinds. Empty means all kinds.
+	Kinds []string
+	// Timeout bounds each call; zero uses 2s.
+	Timeout       time.Duration
+	FailurePolicy FailurePolicy
+}
+
+func (h AdmissionHook) appliesTo(kind string) bool {
+	return len(h.Kinds) == 0 || slices.Contains(h.Kinds, kind)
+}
+
+// checkHookName makes sure name is a single NATS subject token.
+func checkHookName(name string) error {
+	if name == "" || strings.ContainsAny(name, ".*> \t\r\n") {
+		return fmt.Errorf("invalid admission hook name %q", name)
+	}
+	return nil
+}
+
+// ParseAdmissionHooks parses a comma-separated list of hooks, each a name
+// followed by colon-separated options: "mutating" or "validating" (the
+// default), "fail-open" or "fail-closed" (the default), "timeout=<duration>"
+// and "kind=<kind>", which may be repeated. For example
+// "region-defaults:mutating:kind=servers,permission:timeout=500ms".
+func ParseAdmissionHooks(s string) ([]AdmissionHook, error) {
+	var hooks []AdmissionHook
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, opts, _ := strings.Cut(spec, ":")
+		hook := AdmissionHook{Name: name}
+		if err := checkHookName(name); err != nil {
+			return nil, err
+		}
+		for _, opt := range strings.Split(opts, ":") {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "":
+			case "mutating":
+				hook.Type = MutatingHook
+			case "validating":
+				hook.Type = ValidatingHook
+			case "fail-open":
+				hook.FailurePolicy = FailOpen
+			case "fail-closed":
+				hook.FailurePolicy = FailClosed
+			case "timeout":
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("admission hook %s: invalid timeout: %w", name, err)
+				}
+				hook.Timeout = d
+			case "kind":
+				hook.Kinds = append(hook.Kinds, value)
+			default:
+				return nil, fmt.Errorf("admission hook %s: unknown option %q", name, opt)
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// AdmissionOperation is the kind of write a hook is asked about.
+type AdmissionOperation string
+
+const (
+	AdmissionCreate AdmissionOperation = "create"
+	AdmissionUpdate AdmissionOperation = "update"
+)
+
+// AdmissionRequest is sent to a hook as JSON for every write it covers.
+type AdmissionRequest struct {
+	Hook      string             `json:"hook"`
+	Kind      string             `json:"kind"`
+	Key       string             `json:"key"`
+	Operation AdmissionOperation `json:"operation"`
+	// ChangedBy is the identity of the writer (see WithChangedBy).
+	ChangedBy string `json:"changed_by,omitempty"`
+	// Object is the proposed object, including the patches of the mutating
+	// hooks called before this one.
+	Object *Metadata `json:"object"`
+	// OldObject and Revision are the stored object, absent on create.
+	OldObject *Metadata `json:"old_object,omitempty"`
+	Revision  uint64    `json:"revision,omitempty"`
+}
+
+// AdmissionResponse is a hook's answer. A patch is only accepted from
+// mutating hooks.
+type AdmissionResponse struct {
+	Allowed   bool            `json:"allowed"`
+	Reason    string          `json:"reason,omitempty"`
+	PatchType PatchType       `json:"patch_type,omitzero"`
+	Patch     json.RawMessage `json:"patch,omitempty"`
+}
+
+// Allow returns a response allowing the write unchanged.
+func Allow() *AdmissionResponse { return &AdmissionResponse{Allowed: true} }
+
+// Deny returns a response denying the write with reason.
+func Deny(reason string) *AdmissionResponse { return &AdmissionResponse{Reason: reason} }
+
+// AllowWithPatch returns a response allowing the write once patch is applied.
+func AllowWithPatch(patchType PatchType, patch []byte) *AdmissionResponse {
+	return &AdmissionResponse{Allowed: true, PatchType: patchType, Patch: patch}
+}
+
+// ErrAdmissionDenied is matched (via errors.Is) by AdmissionErrors for writes
+// a hook denied.
+var ErrAdmissionDenied = errors.New("metadata: denied by admission hook")
+
+// ErrAdmissionFailed is matched by AdmissionErrors for writes rejected
+// because a fail-closed hook could not be consulted.
+var ErrAdmissionFailed = errors.New("metadata: admission hook failed")
+
+// AdmissionError is returned when an admission hook rejects a write. Nothing
+// is written.
+type AdmissionError struct {
+	Hook   string
+	Bucket string
+	Key    string
+	Reason string // given by the hook when it denied the write
+	Err    error  // why the hook could not be consulted, nil if it denied
+}
+
+func (e *AdmissionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("metadata: admission hook %s failed for %s/%s: %v", e.Hook, e.Bucket, e.Key, e.Err)
+	}
+	if e.Reason == "" {
+		return fmt.Sprintf("metadata: admission hook %s denied %s/%s", e.Hook, e.Bucket, e.Key)
+	}
+	return fmt.Sprintf("metadata: admission hook %s denied %s/%s: %s", e.Hook, e.Bucket, e.Key, e.Reason)
+}
+
+func (e *AdmissionError) Is(target error) bool {
+	if e.Err != nil {
+		return target == ErrAdmissionFailed
+	}
+	return target == ErrAdmissionDenied
+}
+
+func (e *AdmissionError) Unwrap() error { return e.Err }
+
+// admit calls the configured hooks of hookType in order. Patches returned by
+// mutating hooks are applied to next; the changed-by annotation is stamped
+// again afterwards so hooks cannot forge it.
+func (s *Store) admit(ctx context.Context, hookType AdmissionHookType, key string, current, next *Metadata) error {
+	c := s.client
+	for _, hook := range c.config.AdmissionHooks {
+		if hook.Type != hookType || !hook.appliesTo(s.kind.Name) {
+			continue
+		}
+		req := &AdmissionRequest{
+			Hook:      hook.Name,
+			Kind:      s.kind.Name,
+			Key:       key,
+			Operation: AdmissionCreate,
+			Object:    next,
+		}
+		req.ChangedBy, _ = next.GetAnnotation(constant.ChangedBy)
+		if current.Revision != 0 {
+			req.Operation, req.OldObject, req.Revision = AdmissionUpdate, current, current.Revision
+		}
+
+		patched, resp, err := c.callAdmissionHook(ctx, hook, req)
+		if err != nil {
+			if hook.FailurePolicy == FailOpen {
+				c.logger.Warn("Admission hook failed, allowing write", "hook", hook.Name, "bucket", s.backend.Bucket(), "key", key, "error", err)
+				continue
+			}
+			return &AdmissionError{Hook: hook.Name, Bucket: s.backend.Bucket(), Key: key, Err: err}
+		}
+		if !resp.Allowed {
+			return &AdmissionError{Hook: hook.Name, Bucket: s.backend.Bucket(), Key: key, Reason: resp.Reason}
+		}
+		if patched != nil {
+			next.Labels, next.Annotations = patched.Labels, patched.Annotations
+			stampChangedBy(ctx, c.config, next)
+		}
+	}
+	return nil
+}
+
+// callAdmissionHook sends req to hook and returns its response, with the
+// patched object if it returned a patch. Errors mean the hook could not be
+// consulted and are subject to its failure policy.
+func (c *Client) callAdmissionHook(ctx context.Context, hook AdmissionHook, req *AdmissionRequest) (*Metadata, *AdmissionResponse, error) {
+	if c.nc == nil {
+		return nil, nil, errors.New("admission hooks need a NATS connection")
+	}
+	if err := checkHookName(hook.Name); err != nil {
+		return nil, nil, err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultAdmissionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	msg, err := c.nc.RequestWithContext(ctx, string(constant.AdmissionHookSubject(req.Kind, hook.Name)), data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var resp AdmissionResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return nil, nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if !resp.Allowed || resp.Patch == nil {
+		return nil, &resp, nil
+	}
+	if hook.Type != MutatingHook {
+		return nil, nil, errors.New("validating hooks cannot return a patch")
+	}
+	// Patch a copy, so a patch that fails half-way does not leak into a
+	// fail-open write.
+	patched := req.Object.DeepCopy()
+	if err := ApplyPatch(patched, resp.PatchType, resp.Patch); err != nil {
+		return nil, nil, err
+	}
+	return patched, &resp, nil
+}
+
+// AdmissionHandler decides on one proposed write. Returning nil denies it.
+type AdmissionHandler func(ctx context.Context, req *AdmissionRequest) *AdmissionResponse
+
+// AdmissionServer answers admission requests until Stop is called.
+type AdmissionServer struct {
+	subs []*nats.Subscription
+}
+
+// ServeAdmissionHook answers the requests of the hook called name for kinds
+// (all kinds if none are given) with handler. Replicas serving the same hook
+// share its requests. Handlers run on the subscription goroutine, so one slow
+// request delays the next; the caller gives up after the hook's timeout.
+func (c *Client) ServeAdmissionHook(name string, handler AdmissionHandler, kinds ...string) (*AdmissionServer, error) {
+	if c.nc == nil {
+		return nil, errors.New("admission hooks need a NATS connection")
+	}
+	if err := checkHookName(name); err != nil {
+		return nil, err
+	}
+	if len(kinds) == 0 {
+		kinds = []string{"*"}
+	}
+	srv := &AdmissionServer{}
+	for _, kind := range kinds {
+		subject := string(constant.AdmissionHookSubject(kind, name))
+		sub, err := c.nc.QueueSubscribe(subject, name, func(msg *nats.Msg) {
+			c.answerAdmission(msg, handler)
+		})
+		if err != nil {
+			srv.Stop()
+			return nil, fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+		}
+		srv.subs = append(srv.subs, sub)
+	}
+	// Make sure the server knows about the subscriptions before writers call them.
+	if err := c.nc.Flush(); err != nil {
+		srv.Stop()
+		return nil, err
+	}
+	return srv, nil
+}
+
+func (c *Client) answerAdmission(msg *nats.Msg, handler AdmissionHandler) {
+	var req AdmissionRequest
+	resp := Deny("invalid admission request")
+	if err := json.Unmarshal(msg.Data, &req); err == nil {
+		if resp = handler(c.ctx, &req); resp == nil {
+			resp = Deny("")
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(Deny(fmt.Sprintf("invalid admission response: %v", err)))
+	}
+	if err := msg.Respond(data); err != nil {
+		c.logger.Warn("Failed to answer admission request", "subject", msg.Subject, "error", err)
+	}
+}
+
+// Stop unsubscribes, letting requests already received finish.
+func (s *AdmissionServer) Stop() error {
+	var errs []error
+	for _, sub := range s.subs {
+		errs = append(errs, sub.Drain())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,184 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+// newHookedClient returns a client on s that consults hooks, and a second
+// client to serve them from.
+func newHookedClient(t *testing.T, s *server.Server, changedBy string, hooks ...metadata.AdmissionHook) (*metadata.Client, *metadata.Client) {
+	t.Helper()
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.ChangedBy = changedBy
+	cfg.AdmissionHooks = hooks
+	return metadatatest.NewClientWithConfig(t, cfg), metadatatest.NewClientForServer(t, s)
+}
+
+func serveHook(t *testing.T, client *metadata.Client, name string, handler metadata.AdmissionHandler, kinds ...string) {
+	t.Helper()
+	srv, err := client.ServeAdmissionHook(name, handler, kinds...)
+	if err != nil {
+		t.Fatalf("ServeAdmissionHook failed: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+}
+
+func TestValidatingHookDenies(t *testing.T) {
+	ctx := context.Background()
+	writer, hooks := newHookedClient(t, metadatatest.RunServer(t), "lobby-service",
+		metadata.AdmissionHook{Name: "permission", Kinds: []string{metadata.KindPlayers}})
+	const uuid = "8f0c2a4e-0000-4000-8000-000000000001"
+
+	requests := make(chan *metadata.AdmissionRequest, 4)
+	serveHook(t, hooks, "permission", func(_ context.Context, req *metadata.AdmissionRequest) *metadata.AdmissionResponse {
+		requests <- req
+		var old string
+		if req.OldObject != nil {
+			old, _ = req.OldObject.GetLabel("tier")
+		}
+		if tier, _ := req.Object.GetLabel("tier"); tier != old && req.ChangedBy != "admin" {
+			return metadata.Deny(req.ChangedBy + " may not change tier")
+		}
+		return metadata.Allow()
+	}, metadata.KindPlayers)
+
+	if err := writer.UpdatePlayerContext(ctx, uuid, func(m *metadata.Metadata) { m.SetLabel("region", "eu") }); err != nil {
+		t.Fatalf("allowed update failed: %v", err)
+	}
+	if seen := <-requests; seen.Operation != metadata.AdmissionCreate || seen.Key != uuid || seen.Kind != metadata.KindPlayers || !seen.Object.HasLabel("region", "eu") {
+		t.Fatalf("unexpected request %+v", seen)
+	}
+
+	err := writer.UpdatePlayerContext(ctx, uuid, func(m *metadata.Metadata) { m.SetLabel("tier", "premium") })
+	var admissionErr *metadata.AdmissionError
+	if !errors.As(err, &admissionErr) || !errors.Is(err, metadata.ErrAdmissionDenied) {
+		t.Fatalf("expected the hook to deny, got %v", err)
+	}
+	if admissionErr.Hook != "permission" || admissionErr.Reason != "lobby-service may not change tier" {
+		t.Fatalf("unexpected error %+v", admissionErr)
+	}
+	if seen := <-requests; seen.Operation != metadata.AdmissionUpdate || seen.Revision == 0 {
+		t.Fatalf("expected an update request with the stored revision, got %+v", seen)
+	}
+
+	if err := writer.UpdatePlayerContext(metadata.WithChangedBy(ctx, "admin"), uuid, func(m *metadata.Metadata) { m.SetLabel("tier", "premium") }); err != nil {
+		t.Fatalf("admin update failed: %v", err)
+	}
+	// Servers are not covered by the hook.
+	if err := writer.UpdateServerContext(ctx, "lobby", func(m *metadata.Metadata) { m.SetLabel("tier", "premium") }); err != nil {
+		t.Fatalf("server update failed: %v", err)
+	}
+}
+
+func TestMutatingHooksRunInOrder(t *testing.T) {
+	ctx := context.Background()
+	writer, hooks := newHookedClient(t, metadatatest.RunServer(t), "lobby-service",
+		metadata.AdmissionHook{Name: "require-region"},
+		metadata.AdmissionHook{Name: "region-defaults", Type: metadata.MutatingHook},
+		metadata.AdmissionHook{Name: "zone-defaults", Type: metadata.MutatingHook},
+	)
+
+	serveHook(t, hooks, "region-defaults", func(_ context.Context, req *metadata.AdmissionRequest) *metadata.AdmissionResponse {
+		if _, ok := req.Object.GetLabel("region"); ok {
+			return metadata.Allow()
+		}
+		// Hooks cannot forge the writer's identity.
+		return metadata.AllowWithPatch(metadata.MergePatch, []byte(`{"labels":{"region":"eu"},"annotations":{"metadata/changed-by":"region-defaults"}}`))
+	})
+	serveHook(t, hooks, "zone-defaults", func(_ context.Context, req *metadata.AdmissionRequest) *metadata.AdmissionResponse {
+		region, _ := req.Object.GetLabel("region")
+		return metadata.AllowWithPatch(metadata.JSONPatch, []byte(`[{"op":"add","path":"/labels/zone","value":"`+region+`-1"}]`))
+	})
+	serveHook(t, hooks, "require-region", func(_ context.Context, req *metadata.AdmissionRequest) *metadata.AdmissionResponse {
+		if _, ok := req.Object.GetLabel("region"); !ok {
+			return metadata.Deny("region is required")
+		}
+		return metadata.Allow()
+	})
+
+	m, err := writer.Servers().UpdateAndWait(ctx, "lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") })
+	if err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if !m.HasLabel("region", "eu") || !m.HasLabel("zone", "eu-1") || !m.HasLabel("mode", "lobby") {
+		t.Fatalf("expected defaulted labels, got %v", m.Labels)
+	}
+	if changedBy, _ := m.GetAnnotation("metadata/changed-by"); changedBy != "lobby-service" {
+		t.Fatalf("expected changed-by to stay lobby-service, got %q", changedBy)
+	}
+}
+
+func TestAdmissionFailurePolicy(t *testing.T) {
+	ctx := context.Background()
+	s := metadatatest.RunServer(t)
+
+	closed, _ := newHookedClient(t, s, "", metadata.AdmissionHook{Name: "absent"})
+	err := closed.UpdateServerContext(ctx, "lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") })
+	if !errors.Is(err, metadata.ErrAdmissionFailed) {
+		t.Fatalf("expected a fail-closed hook without responders to reject, got %v", err)
+	}
+
+	open, hooks := newHookedClient(t, s, "",
+		metadata.AdmissionHook{Name: "absent", FailurePolicy: metadata.FailOpen},
+		metadata.AdmissionHook{Name: "slow", Timeout: 50 * time.Millisecond},
+	)
+	release := make(chan struct{})
+	serveHook(t, hooks, "slow", func(context.Context, *metadata.AdmissionRequest) *metadata.AdmissionResponse {
+		<-release
+		return metadata.Allow()
+	})
+	start := time.Now()
+	err = open.UpdateServerContext(ctx, "lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") })
+	close(release)
+	if !errors.Is(err, metadata.ErrAdmissionFailed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the slow hook to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the hook timeout was not applied, took %v", elapsed)
+	}
+
+	open, _ = newHookedClient(t, s, "", metadata.AdmissionHook{Name: "absent", FailurePolicy: metadata.FailOpen})
+	if err := open.UpdateServerContext(ctx, "lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("expected a fail-open hook to let the write through, got %v", err)
+	}
+}
+
+func TestParseAdmissionHooks(t *testing.T) {
+	hooks, err := metadata.ParseAdmissionHooks("region-defaults:mutating:kind=servers:kind=players, permission:fail-open:timeout=500ms")
+	if err != nil {
+		t.Fatalf("ParseAdmissionHooks failed: %v", err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("expected 2 hooks, got %+v", hooks)
+	}
+	if h := hooks[0]; h.Name != "region-defaults" || h.Type != metadata.MutatingHook || len(h.Kinds) != 2 || h.FailurePolicy != metadata.FailClosed {
+		t.Fatalf("unexpected first hook %+v", h)
+	}
+	if h := hooks[1]; h.Name != "permission" || h.Type != metadata.ValidatingHook || h.FailurePolicy != metadata.FailOpen || h.Timeout != 500*time.Millisecond {
+		t.Fatalf("unexpected second hook %+v", h)
+	}
+
+	for _, s := range []string{"permission:sometimes", "permission:timeout=soon", "stellaroot.permission", ":mutating"} {
+		if _, err := metadata.ParseAdmissionHooks(s); err == nil {
+			t.Fatalf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestInvalidAdmissionHooksEnv(t *testing.T) {
+	t.Setenv("ADMISSION_HOOKS", "permission:sometimes")
+	_, err := metadata.NewClientWithStorage(context.Background(), metadata.NewConfigFromEnv(), metadata.NewMemoryStorage(), slog.Default())
+	if err == nil || !strings.Contains(err.Error(), "invalid ADMISSION_HOOKS") || !strings.Contains(err.Error(), "sometimes") {
+		t.Fatalf("expected the ADMISSION_HOOKS parse error, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRevisionMismatch is returned by a Backend when a conditional write finds
+// that the key is no longer at the expected revision (or, for Create, that it
+// already exists).
+var ErrRevisionMismatch = errors.New("metadata: revision mismatch")
+
+// EntryOperation is the kind of write an Entry records.
+type EntryOperation int
+
+const (
+	EntryPut EntryOperation = iota
+	EntryDelete
+	EntryPurge
+)
+
+// Entry is one revision of a key as returned by a Backend.
+type Entry struct {
+	Key       string
+	Value     []byte
+	Revision  uint64
+	Created   time.Time
+	Operation EntryOperation
+}
+
+// Backend is the key-value storage of a single bucket. Revisions are
+// bucket-wide and strictly increasing, like JetStream stream sequences.
+// Every call gives up with ctx.Err() once ctx is done.
+type Backend interface {
+	// Bucket returns the bucket name, used in errors and logs.
+	Bucket() string
+	// Get returns the latest entry of key, or ErrNotFound if it is absent or deleted.
+	Get(ctx context.Context, key string) (*Entry, error)
+	// Put writes value unconditionally and returns the new revision.
+	Put(ctx context.Context, key string, value []byte) (uint64, error)
+	// Create writes value only if key is absent or deleted.
+	Create(ctx context.Context, key string, value []byte) (uint64, error)
+	// Update writes value only if revision is the latest revision of key.
+	Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error)
+	// Delete places a delete marker on key. A non-zero revision makes it conditional.
+	Delete(ctx context.Context, key string, revision uint64) error
+	// Purge deletes key and drops its history. A non-zero revision makes it conditional.
+	Purge(ctx context.Context, key string, revision uint64) error
+	// Keys lists the keys that are not deleted.
+	Keys(ctx context.Context) ([]string, error)
+	// History returns the revisions of key still kept by the bucket, oldest
+	// first and delete markers included, or ErrNotFound if there are none.
+	History(ctx context.Context, key string) ([]*Entry, error)
+	// Watch delivers the latest entry of every key (delete markers included),
+	// then a nil entry once the initial values are delivered, then live updates.
+	// A non-zero fromRevision resumes instead: every entry at or after that
+	// revision is delivered in order before the nil entry.
+	Watch(ctx context.Context, fromRevision uint64) (Watcher, error)
+}
+
+// Watcher is a running watch over a Backend.
+type Watcher interface {
+	Updates() <-chan *Entry
+	Stop() error
+}
+
+// BucketStorage is where a bucket keeps its data.
+type BucketStorage int
+
+const (
+	// BucketStorageFile persists the bucket on disk.
+	BucketStorageFile BucketStorage = iota
+	// BucketStorageMemory keeps the bucket in memory; it is lost on restart.
+	BucketStorageMemory
+)
+
+func (s BucketStorage) String() string {
+	switch s {
+	case BucketStorageFile:
+		return "file"
+	case BucketStorageMemory:
+		return "memory"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseBucketStorage parses "file" or "memory".
+func ParseBucketStorage(s string) (BucketStorage, error) {
+	switch s {
+	case "file":
+		return BucketStorageFile, nil
+	case "memory":
+		return BucketStorageMemory, nil
+	default:
+		return 0, fmt.Errorf("invalid bucket storage %q, want file or memory", s)
+	}
+}
+
+// BucketConfig describes a bucket to open. Storages that cannot honour a
+// setting ignore it; MemoryStorage only uses History and TTL.
+type BucketConfig struct {
+	Bucket string
+	// History is how many revisions are kept per key. Zero keeps one.
+	History int
+	// TTL expires entries that have not been written for that long. Zero keeps
+	// them forever.
+	TTL time.Duration
+	// Replicas is the number of copies kept in a cluster. Zero means one.
+	Replicas int
+	// Storage selects file or memory storage.
+	Storage BucketStorage
+	// MaxValueSize limits the size of a value in bytes. Zero means no limit.
+	MaxValueSize int
+	// Reconcile creates the bucket if it is missing and updates an existing
+	// one to the settings above. Without it the bucket is opened as it is,
+	// and a missing one is an error.
+	Reconcile bool
+}
+
+// Storage opens the Backend for a bucket, creating it if needed.
+type Storage interface {
+	// Open returns the bucket described by cfg. Unless cfg.Reconcile is set,
+	// an existing bucket is returned as it is and a missing one fails with
+	// ErrBucketNotFound. With it, a missing bucket is created and an existing
+	// one whose settings differ is updated to match cfg where possible;
+	// otherwise Open fails with ErrBucketExists. ErrJetStreamUnavailable is
+	// returned when the underlying store cannot be reached.
+	Open(ctx context.Context, cfg BucketConfig) (Backend, error)
+}
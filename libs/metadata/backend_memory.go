@@ -0,0 +1,331 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage is an in-process Storage with the same revision, conditional
+// write and watch semantics as JetStream KV. It is meant for tests and local
+// tooling; nothing is persisted.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBackend
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{buckets: make(map[string]*memoryBackend)}
+}
+
+// Open returns the bucket, creating it on first use if cfg.Reconcile is set.
+// Opening the same bucket twice returns the same backend, so several clients
+// can share it; the config of the first Open wins.
+func (s *MemoryStorage) Open(ctx context.Context, cfg BucketConfig) (Backend, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[cfg.Bucket]
+	if !ok {
+		if !cfg.Reconcile {
+			return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, cfg.Bucket)
+		}
+		b = &memoryBackend{bucket: cfg.Bucket, ttl: cfg.TTL, keep: max(cfg.History, 1), history: make(map[string][]*Entry)}
+		s.buckets[cfg.Bucket] = b
+	}
+	return b, nil
+}
+
+type memoryBackend struct {
+	bucket string
+	ttl    time.Duration
+	keep   int // revisions kept per key
+
+	mu       sync.Mutex
+	revision uint64
+	history  map[string][]*Entry // oldest first; never empty once a key exists
+	watchers map[*memoryWatcher]struct{}
+}
+
+func (b *memoryBackend) Bucket() string { return b.bucket }
+
+func (b *memoryBackend) bucketTTL(ctx context.Context) (time.Duration, error) { return b.ttl, nil }
+
+// latestLocked returns the latest entry of key, including delete markers.
+func (b *memoryBackend) latestLocked(key string) *Entry {
+	h := b.history[key]
+	if len(h) == 0 {
+		return nil
+	}
+	return h[len(h)-1]
+}
+
+// liveLocked returns the latest entry of key if it is a value that has not
+// expired. Expired entries are dropped lazily and, unlike JetStream, without a
+// watch notification.
+func (b *memoryBackend) liveLocked(key string) *Entry {
+	e := b.latestLocked(key)
+	if e == nil || e.Operation != EntryPut || b.expired(e) {
+		return nil
+	}
+	return e
+}
+
+func (b *memoryBackend) expired(e *Entry) bool {
+	return b.ttl > 0 && time.Since(e.Created) > b.ttl
+}
+
+func (b *memoryBackend) Get(ctx context.Context, key string) (*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.liveLocked(key)
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	return copyEntry(e), nil
+}
+
+func (b *memoryBackend) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.appendLocked(key, value, EntryPut), nil
+}
+
+func (b *memoryBackend) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.liveLocked(key) != nil {
+		return 0, ErrRevisionMismatch
+	}
+	return b.appendLocked(key, value, EntryPut), nil
+}
+
+func (b *memoryBackend) Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.atRevisionLocked(key, revision) {
+		return 0, ErrRevisionMismatch
+	}
+	return b.appendLocked(key, value, EntryPut), nil
+}
+
+func (b *memoryBackend) Delete(ctx context.Context, key string, revision uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if revision != 0 && !b.atRevisionLocked(key, revision) {
+		return ErrRevisionMismatch
+	}
+	b.appendLocked(key, nil, EntryDelete)
+	return nil
+}
+
+func (b *memoryBackend) Purge(ctx context.Context, key string, revision uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if revision != 0 && !b.atRevisionLocked(key, revision) {
+		return ErrRevisionMismatch
+	}
+	b.appendLocked(key, nil, EntryPurge)
+	// Like a JetStream rollup, only the purge marker survives.
+	h := b.history[key]
+	b.history[key] = h[len(h)-1:]
+	return nil
+}
+
+func (b *memoryBackend) Keys(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for key := range b.history {
+		if b.liveLocked(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (b *memoryBackend) History(ctx context.Context, key string) ([]*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []*Entry
+	for _, e := range b.history[key] {
+		if !b.expired(e) {
+			entries = append(entries, copyEntry(e))
+		}
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
+
+func (b *memoryBackend) atRevisionLocked(key string, revision uint64) bool {
+	e := b.latestLocked(key)
+	if e == nil || b.expired(e) {
+		return revision == 0
+	}
+	return e.Revision == revision
+}
+
+// appendLocked records a new revision of key and fans it out to watchers.
+func (b *memoryBackend) appendLocked(key string, value []byte, op EntryOperation) uint64 {
+	b.revision++
+	e := &Entry{
+		Key:       key,
+		Value:     append([]byte(nil), value...),
+		Revision:  b.revision,
+		Created:   time.Now().UTC(),
+		Operation: op,
+	}
+	h := append(b.history[key], e)
+	if len(h) > b.keep {
+		h = h[len(h)-b.keep:]
+	}
+	b.history[key] = h
+	for w := range b.watchers {
+		w.push(copyEntry(e))
+	}
+	return e.Revision
+}
+
+func (b *memoryBackend) Watch(ctx context.Context, fromRevision uint64) (Watcher, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w := newMemoryWatcher(b)
+	var initial []*Entry
+	for _, h := range b.history {
+		if fromRevision == 0 {
+			if e := h[len(h)-1]; !b.expired(e) {
+				initial = append(initial, copyEntry(e))
+			}
+			continue
+		}
+		for _, e := range h {
+			if e.Revision >= fromRevision && !b.expired(e) {
+				initial = append(initial, copyEntry(e))
+			}
+		}
+	}
+	sort.Slice(initial, func(i, j int) bool { return initial[i].Revision < initial[j].Revision })
+	for _, e := range initial {
+		w.push(e)
+	}
+	w.push(nil) // end of initial values
+
+	if b.watchers == nil {
+		b.watchers = make(map[*memoryWatcher]struct{})
+	}
+	b.watchers[w] = struct{}{}
+	return w, nil
+}
+
+// memoryWatcher buffers entries without bound so writers never block on a
+// slow consumer, and delivers them in revision order.
+type memoryWatcher struct {
+	backend *memoryBackend
+	updates chan *Entry
+
+	mu     sync.Mutex
+	queue  []*Entry
+	signal chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newMemoryWatcher(b *memoryBackend) *memoryWatcher {
+	w := &memoryWatcher{
+		backend: b,
+		updates: make(chan *Entry),
+		signal:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *memoryWatcher) push(e *Entry) {
+	w.mu.Lock()
+	w.queue = append(w.queue, e)
+	w.mu.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) run() {
+	defer close(w.updates)
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			select {
+			case <-w.signal:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		select {
+		case w.updates <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *memoryWatcher) Updates() <-chan *Entry { return w.updates }
+
+func (w *memoryWatcher) Stop() error {
+	w.once.Do(func() {
+		w.backend.mu.Lock()
+		delete(w.backend.watchers, w)
+		w.backend.mu.Unlock()
+		close(w.done)
+	})
+	return nil
+}
+
+func copyEntry(e *Entry) *Entry {
+	c := *e
+	c.Value = append([]byte(nil), e.Value...)
+	return &c
+}
@@ -0,0 +1,220 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func openMemory(t *testing.T) metadata.Backend {
+	t.Helper()
+	b, err := metadata.NewMemoryStorage().Open(context.Background(), metadata.BucketConfig{Bucket: "test", Reconcile: true})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return b
+}
+
+func nextEntry(t *testing.T, w metadata.Watcher) *metadata.Entry {
+	t.Helper()
+	select {
+	case e := <-w.Updates():
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for watch update")
+		return nil
+	}
+}
+
+func TestMemoryBackendConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	b := openMemory(t)
+
+	rev, err := b.Create(ctx, "a", []byte("1"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := b.Create(ctx, "a", []byte("2")); !errors.Is(err, metadata.ErrRevisionMismatch) {
+		t.Fatalf("expected ErrRevisionMismatch creating an existing key, got %v", err)
+	}
+	if _, err := b.Update(ctx, "a", []byte("2"), rev+10); !errors.Is(err, metadata.ErrRevisionMismatch) {
+		t.Fatalf("expected ErrRevisionMismatch on stale update, got %v", err)
+	}
+	rev2, err := b.Update(ctx, "a", []byte("2"), rev)
+	if err != nil || rev2 <= rev {
+		t.Fatalf("Update failed: rev=%d err=%v", rev2, err)
+	}
+
+	if err := b.Delete(ctx, "a", rev); !errors.Is(err, metadata.ErrRevisionMismatch) {
+		t.Fatalf("expected ErrRevisionMismatch on stale delete, got %v", err)
+	}
+	if err := b.Delete(ctx, "a", rev2); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := b.Get(ctx, "a"); !errors.Is(err, metadata.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if keys, _ := b.Keys(ctx); len(keys) != 0 {
+		t.Fatalf("expected no keys after delete, got %v", keys)
+	}
+	// A deleted key can be created again.
+	if _, err := b.Create(ctx, "a", []byte("3")); err != nil {
+		t.Fatalf("Create after delete failed: %v", err)
+	}
+}
+
+func TestMemoryBackendWatch(t *testing.T) {
+	ctx := context.Background()
+	b := openMemory(t)
+	if _, err := b.Put(ctx, "a", []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := b.Put(ctx, "b", []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := b.Delete(ctx, "b", 0); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	w, err := b.Watch(ctx, 0)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+
+	if e := nextEntry(t, w); e == nil || e.Key != "a" || e.Operation != metadata.EntryPut {
+		t.Fatalf("expected initial put of a, got %+v", e)
+	}
+	if e := nextEntry(t, w); e == nil || e.Key != "b" || e.Operation != metadata.EntryDelete {
+		t.Fatalf("expected initial delete marker of b, got %+v", e)
+	}
+	if e := nextEntry(t, w); e != nil {
+		t.Fatalf("expected nil marker after initial values, got %+v", e)
+	}
+
+	rev, _ := b.Put(ctx, "a", []byte("2"))
+	if e := nextEntry(t, w); e == nil || e.Revision != rev || string(e.Value) != "2" {
+		t.Fatalf("expected live update at revision %d, got %+v", rev, e)
+	}
+	if err := b.Purge(ctx, "a", 0); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if e := nextEntry(t, w); e == nil || e.Operation != metadata.EntryPurge {
+		t.Fatalf("expected purge marker, got %+v", e)
+	}
+
+	w.Stop()
+	if _, ok := <-w.Updates(); ok {
+		t.Fatalf("expected Updates to be closed after Stop")
+	}
+}
+
+func TestMemoryClientSharesStorage(t *testing.T) {
+	storage := metadata.NewMemoryStorage()
+	a := metadatatest.NewMemoryClientForStorage(t, storage)
+	b := metadatatest.NewMemoryClientForStorage(t, storage)
+
+	events := make(chan metadata.MetadataChangeEvent, 8)
+	unsub := b.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) { events <- e })
+	defer unsub()
+
+	if err := a.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	e := waitForEvent(t, events, "lobby")
+	if e.Type != metadata.ChangeTypePut || !e.NewValue.HasLabel("mode", "lobby") {
+		t.Fatalf("unexpected put event: %+v", e)
+	}
+
+	if err := b.DeleteServer("lobby", metadata.WithRevision(e.Revision+1)); !errors.Is(err, metadata.ErrConflict) {
+		t.Fatalf("expected ErrConflict for stale revision, got %v", err)
+	}
+	if err := b.DeleteServer("lobby", metadata.WithRevision(e.Revision)); err != nil {
+		t.Fatalf("DeleteServer failed: %v", err)
+	}
+	if e := waitForEvent(t, events, "lobby"); e.Type != metadata.ChangeTypeDelete {
+		t.Fatalf("expected delete event, got %+v", e)
+	}
+	if err := a.DeleteServer("lobby"); !errors.Is(err, metadata.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryClientHonoursContext(t *testing.T) {
+	client := metadatatest.NewMemoryClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := client.UpdateServerContext(ctx, "lobby", func(*metadata.Metadata) { called = true })
+	if !errors.Is(err, context.Canceled) || called {
+		t.Fatalf("expected context.Canceled before updateFunc runs, got err=%v called=%v", err, called)
+	}
+	if err := client.DeleteServerContext(ctx, "lobby"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled from DeleteServerContext, got %v", err)
+	}
+}
+
+// TestCachedValuesAreCopies mutates values returned by the getters while the
+// watcher applies writes; run with -race to check nothing is shared.
+func TestCachedValuesAreCopies(t *testing.T) {
+	client := metadatatest.NewMemoryClient(t)
+	ctx := context.Background()
+	if _, err := client.UpdateServerAndWait(ctx, "lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServerAndWait failed: %v", err)
+	}
+
+	events := make(chan metadata.MetadataChangeEvent, 64)
+	unsub := client.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) {
+		e.NewValue.SetLabel("phantom", "event")
+		events <- e
+	})
+	defer unsub()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if m, ok := client.GetServer("lobby"); ok {
+					m.SetLabel("phantom", "get")
+				}
+				for _, m := range client.GetAllServers() {
+					m.SetLabel("phantom", "list")
+				}
+				for _, m := range client.GetServersByLabels(map[string]string{"mode": "lobby"}) {
+					m.SetLabel("phantom", "select")
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err := client.UpdateServerAndWait(ctx, "lobby", func(m *metadata.Metadata) { m.SetAnnotation("round", fmt.Sprint(i)) }); err != nil {
+			t.Fatalf("UpdateServerAndWait failed: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	m, _ := client.GetServer("lobby")
+	if _, ok := m.GetLabel("phantom"); ok {
+		t.Fatalf("label set on a returned value leaked into the cache: %+v", m.Labels)
+	}
+	if got := client.GetServersByLabel("phantom", "get"); len(got) != 0 {
+		t.Fatalf("label index picked up a phantom label: %v", got)
+	}
+}
@@ -0,0 +1,274 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsStorage opens JetStream KV buckets.
+type natsStorage struct {
+	js jetstream.JetStream
+}
+
+// Open opens the KV bucket. With cfg.Reconcile it creates a missing bucket and
+// reconciles the settings of an existing one with cfg.
+func (s *natsStorage) Open(ctx context.Context, cfg BucketConfig) (Backend, error) {
+	if !cfg.Reconcile {
+		kv, err := s.js.KeyValue(ctx, cfg.Bucket)
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, cfg.Bucket)
+		}
+		if err != nil {
+			return nil, natsBucketError(cfg.Bucket, err)
+		}
+		return &natsBackend{kv: kv}, nil
+	}
+
+	if cfg.History > jetstream.KeyValueMaxHistory {
+		return nil, fmt.Errorf("%s bucket history %d exceeds the maximum of %d", cfg.Bucket, cfg.History, jetstream.KeyValueMaxHistory)
+	}
+	kv, err := s.js.KeyValue(ctx, cfg.Bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = s.js.CreateKeyValue(ctx, natsKeyValueConfig(cfg))
+		if err == nil {
+			return &natsBackend{kv: kv}, nil
+		}
+		if errors.Is(err, jetstream.ErrBucketExists) {
+			// Another client created it first; reconcile theirs.
+			kv, err = s.js.KeyValue(ctx, cfg.Bucket)
+		}
+	}
+	if err != nil {
+		return nil, natsBucketError(cfg.Bucket, err)
+	}
+
+	if kv, err = s.reconcile(ctx, kv, cfg); err != nil {
+		return nil, err
+	}
+	return &natsBackend{kv: kv}, nil
+}
+
+// reconcile updates an existing bucket whose settings differ from cfg. The
+// storage type of a stream cannot change, so a mismatch there is an error.
+func (s *natsStorage) reconcile(ctx context.Context, kv jetstream.KeyValue, cfg BucketConfig) (jetstream.KeyValue, error) {
+	status, err := kv.Status(ctx)
+	if err != nil {
+		return nil, natsBucketError(cfg.Bucket, err)
+	}
+	bucketStatus, ok := status.(*jetstream.KeyValueBucketStatus)
+	if !ok {
+		return kv, nil
+	}
+	current := bucketStatus.StreamInfo().Config
+	want := natsKeyValueConfig(cfg)
+
+	if current.Storage != want.Storage {
+		return nil, fmt.Errorf("%w: %s uses %s storage, configured %s", ErrBucketExists, cfg.Bucket, current.Storage, want.Storage)
+	}
+	if current.MaxMsgsPerSubject == max(int64(want.History), 1) &&
+		current.MaxAge == want.TTL &&
+		current.Replicas == max(want.Replicas, 1) &&
+		current.MaxMsgSize == natsMaxValueSize(want.MaxValueSize) {
+		return kv, nil
+	}
+
+	kv, err = s.js.UpdateKeyValue(ctx, want)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update %s bucket settings: %w", cfg.Bucket, natsBucketError(cfg.Bucket, err))
+	}
+	return kv, nil
+}
+
+func natsKeyValueConfig(cfg BucketConfig) jetstream.KeyValueConfig {
+	storage := jetstream.FileStorage
+	if cfg.Storage == BucketStorageMemory {
+		storage = jetstream.MemoryStorage
+	}
+	return jetstream.KeyValueConfig{
+		Bucket:       cfg.Bucket,
+		History:      uint8(cfg.History),
+		TTL:          cfg.TTL,
+		Replicas:     cfg.Replicas,
+		Storage:      storage,
+		MaxValueSize: int32(cfg.MaxValueSize),
+	}
+}
+
+// natsMaxValueSize is the stream MaxMsgSize JetStream stores for a value size.
+func natsMaxValueSize(size int32) int32 {
+	if size == 0 {
+		return -1
+	}
+	return size
+}
+
+// natsBucketError tells a missing JetStream apart from other bucket errors.
+func natsBucketError(bucket string, err error) error {
+	// Without JetStream nobody answers the API requests.
+	if errors.Is(err, jetstream.ErrJetStreamNotEnabled) || errors.Is(err, jetstream.ErrJetStreamNotEnabledForAccount) ||
+		errors.Is(err, nats.ErrNoResponders) {
+		return fmt.Errorf("%w: opening %s bucket: %w", ErrJetStreamUnavailable, bucket, err)
+	}
+	return err
+}
+
+// natsBackend adapts a jetstream.KeyValue to Backend.
+type natsBackend struct {
+	kv jetstream.KeyValue
+}
+
+func (b *natsBackend) Bucket() string { return b.kv.Bucket() }
+
+func (b *natsBackend) bucketTTL(ctx context.Context) (time.Duration, error) {
+	status, err := b.kv.Status(ctx)
+	if err != nil {
+		return 0, natsError(err)
+	}
+	return status.TTL(), nil
+}
+
+func (b *natsBackend) Get(ctx context.Context, key string) (*Entry, error) {
+	entry, err := b.kv.Get(ctx, key)
+	if err != nil {
+		return nil, natsError(err)
+	}
+	return natsEntry(entry), nil
+}
+
+func (b *natsBackend) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	rev, err := b.kv.Put(ctx, key, value)
+	return rev, natsError(err)
+}
+
+func (b *natsBackend) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	rev, err := b.kv.Create(ctx, key, value)
+	return rev, natsError(err)
+}
+
+func (b *natsBackend) Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	rev, err := b.kv.Update(ctx, key, value, revision)
+	return rev, natsError(err)
+}
+
+func (b *natsBackend) Delete(ctx context.Context, key string, revision uint64) error {
+	return natsError(b.kv.Delete(ctx, key, natsDeleteOpts(revision)...))
+}
+
+func (b *natsBackend) Purge(ctx context.Context, key string, revision uint64) error {
+	return natsError(b.kv.Purge(ctx, key, natsDeleteOpts(revision)...))
+}
+
+func (b *natsBackend) Keys(ctx context.Context) ([]string, error) {
+	keys, err := b.kv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+func (b *natsBackend) History(ctx context.Context, key string) ([]*Entry, error) {
+	history, err := b.kv.History(ctx, key)
+	if err != nil {
+		return nil, natsError(err)
+	}
+	entries := make([]*Entry, len(history))
+	for i, entry := range history {
+		entries[i] = natsEntry(entry)
+	}
+	return entries, nil
+}
+
+func (b *natsBackend) Watch(ctx context.Context, fromRevision uint64) (Watcher, error) {
+	var opts []jetstream.WatchOpt
+	if fromRevision > 0 {
+		// Every revision from there on, not only the latest per key.
+		opts = append(opts, jetstream.IncludeHistory(), jetstream.ResumeFromRevision(fromRevision))
+	}
+	w, err := b.kv.WatchAll(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	nw := &natsWatcher{w: w, updates: make(chan *Entry), done: make(chan struct{})}
+	go nw.run()
+	return nw, nil
+}
+
+// natsWatcher converts jetstream.KeyValueEntry updates into *Entry.
+type natsWatcher struct {
+	w       jetstream.KeyWatcher
+	updates chan *Entry
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (w *natsWatcher) run() {
+	defer close(w.updates)
+	stopped := false
+	// Keep draining after Stop until the subscription closes the source
+	// channel, so its delivery goroutine never blocks on a full buffer.
+	for entry := range w.w.Updates() {
+		if stopped {
+			continue
+		}
+		var e *Entry
+		if entry != nil {
+			e = natsEntry(entry)
+		}
+		select {
+		case w.updates <- e:
+		case <-w.done:
+			stopped = true
+		}
+	}
+}
+
+func (w *natsWatcher) Updates() <-chan *Entry { return w.updates }
+
+func (w *natsWatcher) Stop() error {
+	w.once.Do(func() { close(w.done) })
+	return w.w.Stop()
+}
+
+func natsEntry(entry jetstream.KeyValueEntry) *Entry {
+	op := EntryPut
+	switch entry.Operation() {
+	case jetstream.KeyValueDelete:
+		op = EntryDelete
+	case jetstream.KeyValuePurge:
+		op = EntryPurge
+	}
+	return &Entry{
+		Key:       entry.Key(),
+		Value:     entry.Value(),
+		Revision:  entry.Revision(),
+		Created:   entry.Created(),
+		Operation: op,
+	}
+}
+
+func natsDeleteOpts(revision uint64) []jetstream.KVDeleteOpt {
+	if revision == 0 {
+		return nil
+	}
+	return []jetstream.KVDeleteOpt{jetstream.LastRevision(revision)}
+}
+
+// natsError maps KV errors onto the Backend error contract.
+func natsError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, jetstream.ErrKeyNotFound):
+		return ErrNotFound
+	case errors.Is(err, jetstream.ErrKeyExists):
+		return errors.Join(ErrRevisionMismatch, err)
+	default:
+		return err
+	}
+}
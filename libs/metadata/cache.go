@@ -1,56 +1,99 @@
 package metadata
 
 import (
+	"context"
 	"encoding/json"
-
-	"github.com/nats-io/nats.go"
-
-	"github.com/bafbi/stellaroot/libs/constant"
 )
 
-// warmUpCaches loads initial state from KV into memory caches.
-func (c *Client) warmUpCaches() error {
-	// Players
-	entries, err := c.playersKV.Keys()
-	if err != nil && err != nats.ErrNoKeysFound {
-		return err
+// HasSynced reports whether the store's watcher has delivered the initial
+// contents of the bucket, i.e. whether Get and List reflect the bucket.
+func (s *Store) HasSynced() bool {
+	select {
+	case <-s.synced:
+		return true
+	default:
+		return false
 	}
-	for _, uuid := range entries {
-		val, err := c.playersKV.Get(uuid)
-		if err != nil || val == nil {
-			continue
-		}
-		var player Metadata
-		if err := json.Unmarshal(val.Value(), &player); err != nil {
-			continue
+}
+
+// WaitForSync blocks until HasSynced is true or ctx is done.
+func (s *Store) WaitForSync(ctx context.Context) error {
+	select {
+	case <-s.synced:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// markSynced is called when the watcher reports the end of its initial values.
+func (s *Store) markSynced() {
+	s.syncOnce.Do(func() { close(s.synced) })
+}
+
+// WaitForRevision blocks until the watcher has applied revision (or a later
+// one) to the cache, or ctx is done.
+func (s *Store) WaitForRevision(ctx context.Context, revision uint64) error {
+	for {
+		s.mu.RLock()
+		applied, advanced := s.revision, s.advanced
+		s.mu.RUnlock()
+		if applied >= revision {
+			return nil
 		}
-		c.playersMu.Lock()
-		c.playersCache[uuid] = &player
-		if player.Annotations != nil {
-			if name, ok := player.Annotations[string(constant.PlayerUsername)]; ok && name != "" {
-				c.playersNameToUUID[name] = uuid
-			}
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		c.playersMu.Unlock()
 	}
+}
 
-	// Servers
-	serverKeys, err := c.serversKV.Keys()
-	if err != nil && err != nats.ErrNoKeysFound {
-		return err
-	}
-	for _, name := range serverKeys {
-		val, err := c.serversKV.Get(name)
-		if err != nil || val == nil {
-			continue
+// advanceLocked records revision as applied and wakes WaitForRevision callers.
+// Callers must hold s.mu.
+func (s *Store) advanceLocked(revision uint64) {
+	s.revision = revision
+	close(s.advanced)
+	s.advanced = make(chan struct{})
+}
+
+// HasSynced reports whether every registered kind has synced.
+func (c *Client) HasSynced() bool {
+	for _, s := range c.registeredStores() {
+		if !s.HasSynced() {
+			return false
 		}
-		var server Metadata
-		if err := json.Unmarshal(val.Value(), &server); err != nil {
-			continue
+	}
+	return true
+}
+
+// WaitForSync blocks until every registered kind has synced or ctx is done.
+func (c *Client) WaitForSync(ctx context.Context) error {
+	for _, s := range c.registeredStores() {
+		if err := s.WaitForSync(ctx); err != nil {
+			return err
 		}
-		c.serversMu.Lock()
-		c.serversCache[name] = &server
-		c.serversMu.Unlock()
 	}
 	return nil
 }
+
+func (c *Client) registeredStores() []*Store {
+	c.storesMu.RLock()
+	defer c.storesMu.RUnlock()
+	stores := make([]*Store, 0, len(c.stores))
+	for _, s := range c.stores {
+		stores = append(stores, s)
+	}
+	return stores
+}
+
+// decodeEntry unmarshals a backend entry and attaches its revision and timestamp.
+func decodeEntry(entry *Entry) (*Metadata, error) {
+	var m Metadata
+	if err := json.Unmarshal(entry.Value, &m); err != nil {
+		return nil, err
+	}
+	m.Revision = entry.Revision
+	m.UpdatedAt = entry.Created
+	return &m, nil
+}
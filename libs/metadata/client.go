@@ -6,24 +6,28 @@ import (
 	"log/slog"
 	"sync"
 
-	"github.com/asaskevich/EventBus"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/bafbi/stellaroot/libs/constant"
 )
 
 type Client struct {
 	config *Config
 	nc     *nats.Conn
-	js     nats.JetStreamContext
+	js     jetstream.JetStream
+
+	storage Storage
 
-	playersKV nats.KeyValue
-	serversKV nats.KeyValue
+	stores   map[string]*Store
+	storesMu sync.RWMutex
 
-	playersCache      map[string]*Metadata
-	playersNameToUUID map[string]string // maps player name to UUID
-	serversCache      map[string]*Metadata
+	players *Store
+	servers *Store
 
-	playersMu sync.RWMutex
-	serversMu sync.RWMutex
+	liveness *liveness
+
+	schema *Schema
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -32,50 +36,94 @@ type Client struct {
 
 	wg sync.WaitGroup
 
-	eventBus EventBus.Bus
-
 	watcherStatusCh chan WatcherStatus
 }
 
+// NewClient connects to NATS and keeps the player and server buckets in
+// JetStream KV.
 func NewClient(parentCtx context.Context, config *Config, logger *slog.Logger) (*Client, error) {
-	ctx, cancel := context.WithCancel(parentCtx)
-
-	client := &Client{
-		config:            config,
-		playersCache:      make(map[string]*Metadata),
-		playersNameToUUID: make(map[string]string),
-		serversCache:      make(map[string]*Metadata),
-		ctx:               ctx,
-		cancel:            cancel,
-		logger:            logger,
-		eventBus:          EventBus.New(),
-		watcherStatusCh:   make(chan WatcherStatus, 4),
+	if config.err != nil {
+		return nil, config.err
 	}
+	client := newClient(parentCtx, config, logger)
 
 	if err := client.connect(); err != nil {
-		cancel()
+		client.cancel()
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
-	if err := client.initKV(); err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to initialize KV: %w", err)
+	if err := client.registerBuiltinKinds(); err != nil {
+		client.Close()
+		return nil, err
 	}
-
-	if err := client.warmUpCaches(); err != nil {
-		cancel()
+	if err := client.startLiveness(); err != nil {
+		client.Close()
 		return nil, err
 	}
+	return client, nil
+}
 
-	client.wg.Add(2)
-	go client.watchPlayers()
-	go client.watchServers()
+// NewClientWithStorage creates a client on top of storage instead of NATS,
+// e.g. a MemoryStorage in tests. The NATS settings of config are ignored.
+func NewClientWithStorage(parentCtx context.Context, config *Config, storage Storage, logger *slog.Logger) (*Client, error) {
+	if config.err != nil {
+		return nil, config.err
+	}
+	client := newClient(parentCtx, config, logger)
+	client.storage = storage
 
+	if err := client.registerBuiltinKinds(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := client.startLiveness(); err != nil {
+		client.Close()
+		return nil, err
+	}
 	return client, nil
 }
 
+func newClient(parentCtx context.Context, config *Config, logger *slog.Logger) *Client {
+	ctx, cancel := context.WithCancel(parentCtx)
+	return &Client{
+		config:          config,
+		stores:          make(map[string]*Store),
+		ctx:             ctx,
+		cancel:          cancel,
+		logger:          logger,
+		schema:          NewSchema(constant.AllAnnotationDescriptors...),
+		watcherStatusCh: make(chan WatcherStatus, 4),
+	}
+}
+
+func (c *Client) registerBuiltinKinds() error {
+	config := c.config
+	players, err := c.RegisterKind(ResourceKind{
+		Name:           KindPlayers,
+		Bucket:         config.PlayersBucket,
+		NameAnnotation: constant.PlayerUsername,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize KV: %w", err)
+	}
+	c.players = players
+
+	servers, err := c.RegisterKind(ResourceKind{
+		Name:   KindServers,
+		Bucket: config.ServersBucket,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize KV: %w", err)
+	}
+	c.servers = servers
+	return nil
+}
+
 func (c *Client) Close() error {
 	c.cancel()
+	for _, s := range c.registeredStores() {
+		s.closeSubscriptions()
+	}
 	c.wg.Wait()
 	if c.nc != nil {
 		c.nc.Close()
@@ -0,0 +1,371 @@
+package metadata_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+// openKV gives tests raw access to a bucket, bypassing the client.
+func openKV(t *testing.T, s *server.Server, bucket string) nats.KeyValue {
+	t.Helper()
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if err != nil {
+		t.Fatalf("KeyValue(%q) failed: %v", bucket, err)
+	}
+	return kv
+}
+
+func waitForEvent(t *testing.T, events <-chan metadata.MetadataChangeEvent, key string) metadata.MetadataChangeEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Key == key {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for event on %q", key)
+		}
+	}
+}
+
+func TestClientPlayerServerFlow(t *testing.T) {
+	client := metadatatest.NewClient(t)
+
+	events := make(chan metadata.MetadataChangeEvent, 8)
+	unsub := client.SubscribeToPlayerChanges(func(e metadata.MetadataChangeEvent) { events <- e })
+	defer unsub()
+	serverEvents := make(chan metadata.MetadataChangeEvent, 8)
+	unsubServers := client.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) { serverEvents <- e })
+	defer unsubServers()
+
+	// Update player and server
+	uuid := "player-123"
+	if err := client.UpdatePlayer(uuid, func(m *metadata.Metadata) {
+		m.SetLabel("tier", "gold")
+		m.SetAnnotation(constant.PlayerUsername, "Hero")
+	}); err != nil {
+		t.Fatalf("UpdatePlayer failed: %v", err)
+	}
+
+	// Wait for watcher to process put
+	waitForEvent(t, events, uuid)
+	p, ok := client.GetPlayer(uuid)
+	if !ok || !p.HasLabel("tier", "gold") {
+		t.Fatalf("player not in cache or missing label: ok=%v p=%+v", ok, p)
+	}
+	if _, ok := client.GetPlayerByName("Hero"); !ok {
+		t.Fatalf("GetPlayerByName failed")
+	}
+
+	if err := client.UpdateServer("srv-1", func(m *metadata.Metadata) { m.SetLabel("region", "eu") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	waitForEvent(t, serverEvents, "srv-1")
+	if s, ok := client.GetServer("srv-1"); !ok || !s.HasLabel("region", "eu") {
+		t.Fatalf("server missing after update")
+	}
+
+	players := client.GetPlayersByLabel("tier", "gold")
+	if len(players) != 1 {
+		t.Fatalf("expected 1 player, got %d", len(players))
+	}
+	servers := client.GetServersByLabel("region", "eu")
+	if len(servers) != 1 {
+		t.Fatalf("expected 1 server, got %d", len(servers))
+	}
+}
+
+func TestClientConcurrentUpdatesDoNotLoseWrites(t *testing.T) {
+	s := metadatatest.RunServer(t)
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.MaxUpdateAttempts = 50
+	a := metadatatest.NewClientWithConfig(t, cfg)
+	b := metadatatest.NewClientWithConfig(t, cfg)
+
+	name := "srv-cas"
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		client := a
+		if i%2 == 1 {
+			client = b
+		}
+		wg.Add(1)
+		go func(i int, client *metadata.Client) {
+			defer wg.Done()
+			if err := client.UpdateServer(name, func(m *metadata.Metadata) {
+				m.SetLabel(fmt.Sprintf("writer-%d", i), "done")
+			}); err != nil {
+				t.Errorf("UpdateServer(%d) failed: %v", i, err)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	entry, err := openKV(t, s, cfg.ServersBucket).Get(name)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	var got metadata.Metadata
+	if err := json.Unmarshal(entry.Value(), &got); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(got.Labels) != writers {
+		t.Fatalf("expected %d labels, got %d: %v", writers, len(got.Labels), got.Labels)
+	}
+}
+
+func TestClientTracksRevisionAndTimestamps(t *testing.T) {
+	client := metadatatest.NewClient(t)
+
+	events := make(chan metadata.MetadataChangeEvent, 8)
+	unsub := client.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) { events <- e })
+	defer unsub()
+
+	name := "srv-rev"
+	if err := client.UpdateServer(name, func(m *metadata.Metadata) { m.SetLabel("v", "1") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	first := waitForEvent(t, events, name)
+	if first.Revision == 0 || first.Timestamp.IsZero() {
+		t.Fatalf("expected revision and timestamp on event, got %+v", first)
+	}
+	if first.NewValue.CreatedAt.IsZero() || first.NewValue.Revision != first.Revision {
+		t.Fatalf("expected CreatedAt and Revision on new value, got %+v", first.NewValue)
+	}
+
+	if err := client.UpdateServer(name, func(m *metadata.Metadata) {
+		if m.Revision != first.Revision {
+			t.Errorf("updateFunc saw revision %d, want %d", m.Revision, first.Revision)
+		}
+		m.SetLabel("v", "2")
+	}); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	second := waitForEvent(t, events, name)
+	if second.Revision <= first.Revision {
+		t.Fatalf("expected revision to grow: %d -> %d", first.Revision, second.Revision)
+	}
+	if !second.NewValue.CreatedAt.Equal(first.NewValue.CreatedAt) {
+		t.Fatalf("CreatedAt changed on update: %v -> %v", first.NewValue.CreatedAt, second.NewValue.CreatedAt)
+	}
+	if s, ok := client.GetServer(name); !ok || s.Revision != second.Revision {
+		t.Fatalf("cache not at latest revision: %+v", s)
+	}
+}
+
+func TestClientUpdateAndWaitReadsOwnWrite(t *testing.T) {
+	client := metadatatest.NewClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for i := 1; i <= 3; i++ {
+		want := fmt.Sprint(i)
+		m, err := client.UpdatePlayerAndWait(ctx, "player-ryw", func(m *metadata.Metadata) { m.SetLabel("v", want) })
+		if err != nil {
+			t.Fatalf("UpdatePlayerAndWait failed: %v", err)
+		}
+		if !m.HasLabel("v", want) {
+			t.Fatalf("returned metadata is stale: %+v", m)
+		}
+		// The cache must already hold the write, no polling.
+		if p, ok := client.GetPlayer("player-ryw"); !ok || p.Revision < m.Revision || !p.HasLabel("v", want) {
+			t.Fatalf("cache is stale after UpdatePlayerAndWait: %+v", p)
+		}
+	}
+}
+
+func TestClientDeleteServer(t *testing.T) {
+	s := metadatatest.RunServer(t)
+	client := metadatatest.NewClientForServer(t, s)
+
+	events := make(chan metadata.MetadataChangeEvent, 8)
+	unsub := client.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) { events <- e })
+	defer unsub()
+
+	name := "srv-del"
+	if err := client.DeleteServer(name); !errors.Is(err, metadata.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing server, got %v", err)
+	}
+
+	if err := client.UpdateServer(name, func(m *metadata.Metadata) { m.SetLabel("v", "1") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	created := waitForEvent(t, events, name)
+
+	if err := client.UpdateServer(name, func(m *metadata.Metadata) { m.SetLabel("v", "2") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	waitForEvent(t, events, name)
+
+	if err := client.DeleteServer(name, metadata.WithRevision(created.Revision)); !errors.Is(err, metadata.ErrConflict) {
+		t.Fatalf("expected ErrConflict for stale revision, got %v", err)
+	}
+
+	if err := client.DeleteServer(name); err != nil {
+		t.Fatalf("DeleteServer failed: %v", err)
+	}
+	deleted := waitForEvent(t, events, name)
+	if deleted.Type != metadata.ChangeTypeDelete || deleted.OldValue == nil {
+		t.Fatalf("expected delete event with old value, got %+v", deleted)
+	}
+	if _, ok := client.GetServer(name); ok {
+		t.Fatalf("server still cached after delete")
+	}
+
+	if err := client.DeleteServer(name, metadata.WithPurge()); err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	history, err := openKV(t, s, "servers").History(name)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 1 || history[0].Operation() != nats.KeyValuePurge {
+		t.Fatalf("expected only the purge marker to remain, got %d entries", len(history))
+	}
+}
+
+func TestClientRegisterKind(t *testing.T) {
+	client := metadatatest.NewClient(t)
+
+	nameKey := constant.AnnotationKey("proxy/name")
+	proxies, err := client.RegisterKind(metadata.ResourceKind{Name: "proxies", Bucket: "proxies", NameAnnotation: nameKey})
+	if err != nil {
+		t.Fatalf("RegisterKind failed: %v", err)
+	}
+	if _, err := client.RegisterKind(metadata.ResourceKind{Name: "proxies", Bucket: "other"}); err == nil {
+		t.Fatalf("expected duplicate registration to fail")
+	}
+	if s, ok := client.Store("proxies"); !ok || s != proxies {
+		t.Fatalf("Store lookup did not return the registered store")
+	}
+
+	events := make(chan metadata.MetadataChangeEvent, 8)
+	unsub := proxies.Subscribe(func(e metadata.MetadataChangeEvent) { events <- e })
+	defer unsub()
+
+	key := "proxy-1"
+	if err := proxies.Update(key, func(m *metadata.Metadata) {
+		m.SetLabel("region", "eu")
+		m.SetAnnotation(nameKey, "velocity-eu")
+	}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	waitForEvent(t, events, key)
+
+	if m, ok := proxies.GetByName("velocity-eu"); !ok || !m.HasLabel("region", "eu") {
+		t.Fatalf("GetByName failed: ok=%v m=%+v", ok, m)
+	}
+	if got := proxies.ListByLabel("region", "eu"); len(got) != 1 {
+		t.Fatalf("expected 1 proxy, got %d", len(got))
+	}
+	if _, ok := client.GetServer(key); ok {
+		t.Fatalf("proxy leaked into servers store")
+	}
+}
+
+func TestClientReconcilesBucketSettings(t *testing.T) {
+	s := metadatatest.RunServer(t)
+	metadatatest.NewClientForServer(t, s)
+
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.BucketHistory = 20
+	cfg.BucketTTL = time.Hour
+	metadatatest.NewClientWithConfig(t, cfg)
+
+	status, err := openKV(t, s, "servers").Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.History() != 20 || status.TTL() != time.Hour {
+		t.Fatalf("expected history 20 and TTL 1h, got %d and %s", status.History(), status.TTL())
+	}
+}
+
+func TestClientWithoutReconcileLeavesBuckets(t *testing.T) {
+	s := metadatatest.RunServer(t)
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.ReconcileBuckets = false
+	// Failing twice shows the first attempt did not create the buckets.
+	for range 2 {
+		if _, err := metadata.NewClient(context.Background(), cfg, slog.New(slog.DiscardHandler)); !errors.Is(err, metadata.ErrBucketNotFound) {
+			t.Fatalf("expected ErrBucketNotFound for missing buckets, got %v", err)
+		}
+	}
+
+	metadatatest.NewClientForServer(t, s)
+	cfg.BucketHistory = 20
+	cfg.BucketStorage = metadata.BucketStorageMemory
+	metadatatest.NewClientWithConfig(t, cfg)
+
+	status, err := openKV(t, s, "servers").Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.History() != 10 {
+		t.Fatalf("expected the bucket settings to be left alone, got history %d", status.History())
+	}
+}
+
+func TestConfigFromEnvReconcileBuckets(t *testing.T) {
+	if !metadata.NewConfigFromEnv().ReconcileBuckets {
+		t.Fatalf("expected buckets to be reconciled by default")
+	}
+	t.Setenv("RECONCILE_BUCKETS", "false")
+	if metadata.NewConfigFromEnv().ReconcileBuckets {
+		t.Fatalf("expected RECONCILE_BUCKETS=false to turn reconciling off")
+	}
+}
+
+func TestClientBucketStorageMismatch(t *testing.T) {
+	s := metadatatest.RunServer(t)
+	metadatatest.NewClientForServer(t, s) // file storage
+
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.BucketStorage = metadata.BucketStorageMemory
+	_, err := metadata.NewClient(context.Background(), cfg, slog.New(slog.DiscardHandler))
+	if !errors.Is(err, metadata.ErrBucketExists) || errors.Is(err, metadata.ErrJetStreamUnavailable) {
+		t.Fatalf("expected ErrBucketExists, got %v", err)
+	}
+}
+
+func TestClientWithoutJetStream(t *testing.T) {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("failed to create nats-server: %v", err)
+	}
+	go s.Start()
+	defer s.Shutdown()
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatalf("nats-server did not become ready")
+	}
+
+	_, err = metadata.NewClient(context.Background(), metadatatest.NewConfig(s.ClientURL()), slog.New(slog.DiscardHandler))
+	if !errors.Is(err, metadata.ErrJetStreamUnavailable) || errors.Is(err, metadata.ErrBucketExists) {
+		t.Fatalf("expected ErrJetStreamUnavailable, got %v", err)
+	}
+}
@@ -1,33 +1,142 @@
 package metadata
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"time"
 )
 
+// defaultBucketHistory is the revisions kept per key by NewConfigFromEnv.
+const defaultBucketHistory = 10
+
 type Config struct {
 	NATSUrl      string
 	NATSUser     string
 	NATSPassword string
 	NATSToken    string
 
+	// NATSCredsFile is a .creds file (user JWT and NKey seed) for
+	// decentralized JWT authentication.
+	NATSCredsFile string
+	// NATSNKeySeedFile is a file holding an NKey user seed.
+	NATSNKeySeedFile string
+
+	// NATSTLSCert and NATSTLSKey are the client certificate and key presented
+	// to servers that verify clients. NATSTLSCA is the CA bundle used to
+	// verify the server instead of the system roots.
+	NATSTLSCert string
+	NATSTLSKey  string
+	NATSTLSCA   string
+
 	PlayersBucket string
 	ServersBucket string
+	// BucketHistory is how many revisions of each player and server the
+	// buckets keep (JetStream allows up to 64). Zero keeps only the latest.
+	BucketHistory int
+	// BucketReplicas, BucketStorage, BucketMaxValueSize and BucketTTL set up
+	// the buckets (see BucketConfig) when ReconcileBuckets is set. Existing
+	// buckets are then updated to match, so every client reconciling a bucket
+	// should use the same values.
+	BucketReplicas     int
+	BucketStorage      BucketStorage
+	BucketMaxValueSize int
+	BucketTTL          time.Duration
+	// ReconcileBuckets lets the client create missing buckets and apply the
+	// Bucket settings above to existing ones. NewConfigFromEnv sets it unless
+	// RECONCILE_BUCKETS is false; without it buckets are used as they are and
+	// a missing one fails with ErrBucketNotFound, so tools such as
+	// stellarootctl turn it off to never change them.
+	ReconcileBuckets bool
+
+	// HeartbeatsBucket holds server heartbeats. Empty disables heartbeats and
+	// lost-server detection.
+	HeartbeatsBucket string
+	// HeartbeatTTL is how long a heartbeat stays alive. A server is reported
+	// lost once it has not sent one for that long. It sets the TTL of a new
+	// heartbeats bucket; an existing one keeps its own, which then wins.
+	HeartbeatTTL time.Duration
+
+	// LocksBucket holds leader leases, such as the garbage collector's.
+	// Empty disables them.
+	LocksBucket string
+	// LeaseTTL is how long a leader lease lasts without being renewed, so
+	// how long it takes another client to take over from a lost leader.
+	LeaseTTL time.Duration
 
 	ReconnectDelay time.Duration
 	MaxReconnects  int
+
+	// OnConnectionEvent, when set, is called on disconnects, reconnects and
+	// when the connection is closed for good. The same events are reported on
+	// the watcher status channel as the ConnectionWatcher.
+	OnConnectionEvent func(ConnectionEvent)
+
+	// ChangedBy identifies this client in the changed-by annotation stamped on
+	// every update. Empty leaves writes unattributed.
+	ChangedBy string
+
+	// MaxUpdateAttempts bounds how many times an update is retried on a
+	// revision conflict before a *ConflictError is returned.
+	MaxUpdateAttempts int
+
+	// AnnotationValidation checks annotation values against the descriptors
+	// of Client.Schema on every write. Off by default.
+	AnnotationValidation ValidationMode
+	// StrictAnnotations makes validation also fail annotations that have no
+	// descriptor. It has no effect while validation is off.
+	StrictAnnotations bool
+
+	// AdmissionHooks are consulted over NATS before every write, mutating
+	// hooks first, each group in order. They need a NATS connection.
+	AdmissionHooks []AdmissionHook
+
+	// err is the first variable NewConfigFromEnv could not parse and must not
+	// ignore. NewClient and NewClientWithStorage fail with it.
+	err error
 }
 
 func NewConfigFromEnv() *Config {
+	hooks, err := getEnvAdmissionHooks("ADMISSION_HOOKS")
 	return &Config{
-		NATSUrl:        getEnv("NATS_URL", "nats://localhost:4222"),
-		NATSUser:       getEnv("NATS_USER", ""),
-		NATSPassword:   getEnv("NATS_PASSWORD", ""),
-		NATSToken:      getEnv("NATS_TOKEN", ""),
-		PlayersBucket:  getEnv("PLAYERS_BUCKET", "players"),
-		ServersBucket:  getEnv("SERVERS_BUCKET", "servers"),
-		ReconnectDelay: 5 * time.Second,
-		MaxReconnects:  -1, // unlimited
+		NATSUrl:      getEnv("NATS_URL", "nats://localhost:4222"),
+		NATSUser:     getEnv("NATS_USER", ""),
+		NATSPassword: getEnv("NATS_PASSWORD", ""),
+		NATSToken:    getEnv("NATS_TOKEN", ""),
+
+		NATSCredsFile:    getEnv("NATS_CREDS_FILE", ""),
+		NATSNKeySeedFile: getEnv("NATS_NKEY_SEED_FILE", ""),
+		NATSTLSCert:      getEnv("NATS_TLS_CERT", ""),
+		NATSTLSKey:       getEnv("NATS_TLS_KEY", ""),
+		NATSTLSCA:        getEnv("NATS_TLS_CA", ""),
+
+		PlayersBucket:    getEnv("PLAYERS_BUCKET", "players"),
+		ServersBucket:    getEnv("SERVERS_BUCKET", "servers"),
+		BucketHistory:    getEnvInt("BUCKET_HISTORY", defaultBucketHistory),
+		BucketReplicas:   getEnvInt("BUCKET_REPLICAS", 1),
+		BucketStorage:    getEnvBucketStorage("BUCKET_STORAGE", BucketStorageFile),
+		BucketTTL:        getEnvDuration("BUCKET_TTL", 0),
+		ReconcileBuckets: getEnvBool("RECONCILE_BUCKETS", true),
+		ChangedBy:        getEnv("METADATA_CHANGED_BY", ""),
+		ReconnectDelay:   5 * time.Second,
+		MaxReconnects:    -1, // unlimited
+
+		MaxUpdateAttempts: getEnvInt("MAX_UPDATE_ATTEMPTS", defaultMaxUpdateAttempts),
+
+		BucketMaxValueSize: getEnvInt("BUCKET_MAX_VALUE_SIZE", 0),
+
+		HeartbeatsBucket: getEnv("HEARTBEATS_BUCKET", "heartbeats"),
+		HeartbeatTTL:     getEnvDuration("HEARTBEAT_TTL", defaultHeartbeatTTL),
+
+		LocksBucket: getEnv("LOCKS_BUCKET", "locks"),
+		LeaseTTL:    getEnvDuration("LEASE_TTL", defaultLeaseTTL),
+
+		AnnotationValidation: getEnvValidationMode("ANNOTATION_VALIDATION", ValidationOff),
+		StrictAnnotations:    getEnvBool("ANNOTATION_STRICT", false),
+
+		AdmissionHooks: hooks,
+
+		err: err,
 	}
 }
 
@@ -37,3 +146,63 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if v, err := strconv.Atoi(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if v, err := time.ParseDuration(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBucketStorage(key string, defaultValue BucketStorage) BucketStorage {
+	if value := os.Getenv(key); value != "" {
+		if v, err := ParseBucketStorage(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if v, err := strconv.ParseBool(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+func getEnvValidationMode(key string, defaultValue ValidationMode) ValidationMode {
+	if value := os.Getenv(key); value != "" {
+		if v, err := ParseValidationMode(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAdmissionHooks parses key with ParseAdmissionHooks. Unlike the other
+// variables an invalid value is not ignored, since dropping a hook would let
+// through writes it should see: the error is returned instead.
+func getEnvAdmissionHooks(key string) ([]AdmissionHook, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+	hooks, err := ParseAdmissionHooks(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return hooks, nil
+}
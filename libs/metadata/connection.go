@@ -1,9 +1,11 @@
 package metadata
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 func (c *Client) connect() error {
@@ -11,57 +13,97 @@ func (c *Client) connect() error {
 		nats.Name("MetadataClient"),
 		nats.ReconnectWait(c.config.ReconnectDelay),
 		nats.MaxReconnects(c.config.MaxReconnects),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			c.connectionEvent(ConnectionEvent{Type: ConnectionDisconnected, Error: err})
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			c.connectionEvent(ConnectionEvent{Type: ConnectionReconnected, URL: nc.ConnectedUrlRedacted()})
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			c.connectionEvent(ConnectionEvent{Type: ConnectionClosed, Error: nc.LastError()})
+		}),
 	}
 
-	if c.config.NATSUser != "" && c.config.NATSPassword != "" {
-		opts = append(opts, nats.UserInfo(c.config.NATSUser, c.config.NATSPassword))
-	}
-
-	if c.config.NATSToken != "" {
-		opts = append(opts, nats.Token(c.config.NATSToken))
+	authOpts, err := c.authOptions()
+	if err != nil {
+		return err
 	}
+	opts = append(opts, authOpts...)
 
 	nc, err := nats.Connect(c.config.NATSUrl, opts...)
 	if err != nil {
 		return err
 	}
 
-	js, err := nc.JetStream()
+	js, err := jetstream.New(nc)
 	if err != nil {
+		nc.Close()
 		return err
 	}
 
 	c.nc = nc
 	c.js = js
+	c.storage = &natsStorage{js: js}
 	return nil
 }
 
-func (c *Client) initKV() error {
-	// Create or get players bucket
-	playersKV, err := c.js.CreateKeyValue(&nats.KeyValueConfig{
-		Bucket: c.config.PlayersBucket,
-	})
-	if err != nil {
-		// Try to get existing bucket
-		playersKV, err = c.js.KeyValue(c.config.PlayersBucket)
-		if err != nil {
-			return fmt.Errorf("failed to create/get players bucket: %w", err)
-		}
+// authOptions returns the credential and TLS options of the config.
+func (c *Client) authOptions() ([]nats.Option, error) {
+	config := c.config
+	var opts []nats.Option
+
+	if config.NATSUser != "" && config.NATSPassword != "" {
+		opts = append(opts, nats.UserInfo(config.NATSUser, config.NATSPassword))
 	}
-	c.playersKV = playersKV
 
-	// Create or get servers bucket
-	serversKV, err := c.js.CreateKeyValue(&nats.KeyValueConfig{
-		Bucket: c.config.ServersBucket,
-	})
-	if err != nil {
-		// Try to get existing bucket
-		serversKV, err = c.js.KeyValue(c.config.ServersBucket)
+	if config.NATSToken != "" {
+		opts = append(opts, nats.Token(config.NATSToken))
+	}
+
+	if config.NATSCredsFile != "" {
+		opts = append(opts, nats.UserCredentials(config.NATSCredsFile))
+	}
+
+	if config.NATSNKeySeedFile != "" {
+		opt, err := nats.NkeyOptionFromSeed(config.NATSNKeySeedFile)
 		if err != nil {
-			return fmt.Errorf("failed to create/get servers bucket: %w", err)
+			return nil, fmt.Errorf("failed to load NKey seed: %w", err)
 		}
+		opts = append(opts, opt)
 	}
-	c.serversKV = serversKV
 
-	return nil
+	if (config.NATSTLSCert == "") != (config.NATSTLSKey == "") {
+		return nil, errors.New("NATS TLS client certificate and key must be set together")
+	}
+	if config.NATSTLSCert != "" {
+		opts = append(opts, nats.ClientCert(config.NATSTLSCert, config.NATSTLSKey))
+	}
+	if config.NATSTLSCA != "" {
+		opts = append(opts, nats.RootCAs(config.NATSTLSCA))
+	}
+
+	return opts, nil
+}
+
+// connectionEvent logs a connection change, reports it on the watcher status
+// channel and passes it to Config.OnConnectionEvent.
+// Nothing is reported once the client is being closed.
+func (c *Client) connectionEvent(event ConnectionEvent) {
+	if c.ctx.Err() != nil {
+		return
+	}
+	switch event.Type {
+	case ConnectionReconnected:
+		c.logger.Info("Reconnected to NATS", "url", event.URL)
+	default:
+		c.logger.Warn("NATS connection lost", "event", event.Type.String(), "error", event.Error)
+	}
+	c.reportWatcherStatus(WatcherStatus{
+		Watcher: ConnectionWatcher,
+		Healthy: event.Type == ConnectionReconnected,
+		Error:   event.Error,
+	})
+	if cb := c.config.OnConnectionEvent; cb != nil {
+		cb(event)
+	}
 }
@@ -0,0 +1,143 @@
+package metadata_test
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func TestClientConnectsWithTLSClientCert(t *testing.T) {
+	s, files := metadatatest.RunTLSServer(t)
+
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.NATSTLSCA = files.CA
+	cfg.NATSTLSCert = files.ClientCert
+	cfg.NATSTLSKey = files.ClientKey
+	client := metadatatest.NewClientWithConfig(t, cfg)
+	if _, err := client.UpdateServerAndWait(context.Background(), "lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServerAndWait over TLS failed: %v", err)
+	}
+
+	// The server verifies clients, so the CA alone is not enough.
+	cfg = metadatatest.NewConfig(s.ClientURL())
+	cfg.NATSTLSCA = files.CA
+	if c, err := metadata.NewClient(context.Background(), cfg, slog.New(slog.DiscardHandler)); err == nil {
+		c.Close()
+		t.Fatalf("expected a client without certificate to be rejected")
+	}
+}
+
+func TestClientConnectsWithNKeySeed(t *testing.T) {
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	pub, _ := user.PublicKey()
+	seed, _ := user.Seed()
+	seedFile := filepath.Join(t.TempDir(), "user.nk")
+	if err := os.WriteFile(seedFile, seed, 0o600); err != nil {
+		t.Fatalf("failed to write seed: %v", err)
+	}
+
+	opts := metadatatest.DefaultOptions(t)
+	opts.Nkeys = []*server.NkeyUser{{Nkey: pub}}
+	s := metadatatest.RunServerWithOptions(t, opts)
+
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.NATSNKeySeedFile = seedFile
+	client := metadatatest.NewClientWithConfig(t, cfg)
+	if err := client.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServer with NKey auth failed: %v", err)
+	}
+
+	if c, err := metadata.NewClient(context.Background(), metadatatest.NewConfig(s.ClientURL()), slog.New(slog.DiscardHandler)); err == nil {
+		c.Close()
+		t.Fatalf("expected a client without NKey to be rejected")
+	}
+}
+
+func TestClientRejectsCertWithoutKey(t *testing.T) {
+	cfg := metadatatest.NewConfig("nats://127.0.0.1:1")
+	cfg.NATSTLSCert = "client.pem"
+	if _, err := metadata.NewClient(context.Background(), cfg, slog.New(slog.DiscardHandler)); err == nil {
+		t.Fatalf("expected an error for a certificate without key")
+	}
+}
+
+func waitForConnectionEvent(t *testing.T, events <-chan metadata.ConnectionEvent, want metadata.ConnectionEventType) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
+
+func waitForConnectionStatus(t *testing.T, statuses <-chan metadata.WatcherStatus, healthy bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case st := <-statuses:
+			if st.Watcher == metadata.ConnectionWatcher && st.Healthy == healthy {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for connection status healthy=%v", healthy)
+		}
+	}
+}
+
+func TestConnectionEventsFeedWatcherStatus(t *testing.T) {
+	opts := metadatatest.DefaultOptions(t)
+	s := metadatatest.RunServerWithOptions(t, opts)
+
+	events := make(chan metadata.ConnectionEvent, 8)
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.MaxReconnects = -1
+	cfg.OnConnectionEvent = func(e metadata.ConnectionEvent) { events <- e }
+	client := metadatatest.NewClientWithConfig(t, cfg)
+	statuses := make(chan metadata.WatcherStatus, 64)
+	go func() {
+		for {
+			select {
+			case st := <-client.WatcherStatusChan():
+				statuses <- st
+			case <-t.Context().Done():
+				return
+			}
+		}
+	}()
+
+	port := s.Addr().(*net.TCPAddr).Port
+	s.Shutdown()
+	s.WaitForShutdown()
+	waitForConnectionEvent(t, events, metadata.ConnectionDisconnected)
+	waitForConnectionStatus(t, statuses, false)
+
+	restarted := metadatatest.DefaultOptions(t)
+	restarted.Port = port
+	restarted.StoreDir = opts.StoreDir
+	metadatatest.RunServerWithOptions(t, restarted)
+	waitForConnectionEvent(t, events, metadata.ConnectionReconnected)
+	waitForConnectionStatus(t, statuses, true)
+	// Close before the restarted server shuts down, so the shutdown does not
+	// wait for a connected client.
+	client.Close()
+}
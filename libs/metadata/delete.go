@@ -0,0 +1,94 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DeleteOption configures DeletePlayer and DeleteServer.
+type DeleteOption func(*deleteOptions)
+
+type deleteOptions struct {
+	purge       bool
+	revision    uint64
+	propagation PropagationPolicy
+}
+
+// WithPurge removes every stored revision of the key instead of only placing a
+// delete marker on top of its history.
+func WithPurge() DeleteOption {
+	return func(o *deleteOptions) { o.purge = true }
+}
+
+// WithRevision makes the delete conditional: it only succeeds if revision is
+// still the latest revision of the key, otherwise a *ConflictError is returned.
+func WithRevision(revision uint64) DeleteOption {
+	return func(o *deleteOptions) { o.revision = revision }
+}
+
+// WithPropagation selects what happens to the dependents of the key, the
+// objects with an owner reference to it. The default is DeleteBackground.
+func WithPropagation(policy PropagationPolicy) DeleteOption {
+	return func(o *deleteOptions) { o.propagation = policy }
+}
+
+// Delete removes key from the store's bucket. ErrNotFound is returned if it
+// does not exist. With DeleteForeground or DeleteOrphan, Delete only marks the
+// key for deletion (see Metadata.Deletion) and a GarbageCollector finishes
+// it; the purge option is applied then.
+func (s *Store) Delete(key string, opts ...DeleteOption) error {
+	return s.DeleteContext(context.Background(), key, opts...)
+}
+
+// DeleteContext is Delete with a context bounding the KV round-trips.
+func (s *Store) DeleteContext(ctx context.Context, key string, opts ...DeleteOption) error {
+	backend := s.backend
+	var o deleteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.propagation != DeleteBackground {
+		return s.markDeletion(ctx, key, o)
+	}
+
+	// A purge is still useful on an already deleted key since it drops the
+	// history, so only plain deletes require the key to exist.
+	if !o.purge && o.revision == 0 {
+		if _, err := backend.Get(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if o.purge {
+		err = backend.Purge(ctx, key, o.revision)
+	} else {
+		err = backend.Delete(ctx, key, o.revision)
+	}
+	if errors.Is(err, ErrRevisionMismatch) {
+		return &ConflictError{Bucket: backend.Bucket(), Key: key, Attempts: 1, Err: err}
+	}
+	return err
+}
+
+// markDeletion records a foreground or orphan deletion of key for the garbage
+// collector, stamped with the writer's identity so the history tells who asked
+// for it. Deleting a key already marked does nothing.
+func (s *Store) markDeletion(ctx context.Context, key string, o deleteOptions) error {
+	_, err := s.write(ctx, key, o.revision, false, func(current, next *Metadata) error {
+		if current.Deletion != nil {
+			return errDeletionPending
+		}
+		next.Deletion = &Deletion{Policy: o.propagation, Purge: o.purge, RequestedAt: time.Now().UTC()}
+		stampChangedBy(ctx, s.client.config, next)
+		return nil
+	})
+	if errors.Is(err, errDeletionPending) {
+		return nil
+	}
+	return err
+}
+
+// errDeletionPending aborts markDeletion on keys already marked.
+var errDeletionPending = errors.New("deletion already pending")
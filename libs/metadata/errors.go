@@ -0,0 +1,41 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned when an operation targets a key that does not exist.
+var ErrNotFound = errors.New("metadata: not found")
+
+// ErrBucketExists is returned when a bucket already exists with settings that
+// cannot be changed to the configured ones, such as its storage type.
+var ErrBucketExists = errors.New("metadata: bucket exists with a different configuration")
+
+// ErrBucketNotFound is returned when a bucket does not exist and the client
+// is not allowed to create it (see Config.ReconcileBuckets).
+var ErrBucketNotFound = errors.New("metadata: bucket not found")
+
+// ErrJetStreamUnavailable is returned when the NATS server has no JetStream,
+// or it is not enabled for the account.
+var ErrJetStreamUnavailable = errors.New("metadata: JetStream unavailable")
+
+// ErrConflict is matched (via errors.Is) by every ConflictError.
+var ErrConflict = errors.New("metadata: revision conflict")
+
+// ConflictError is returned when a write kept losing the race against
+// concurrent writers of the same key and the retry budget was exhausted.
+type ConflictError struct {
+	Bucket   string
+	Key      string
+	Attempts int
+	Err      error // last error returned by the KV store
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("metadata: conflict updating %s/%s after %d attempts: %v", e.Bucket, e.Key, e.Attempts, e.Err)
+}
+
+func (e *ConflictError) Is(target error) bool { return target == ErrConflict }
+
+func (e *ConflictError) Unwrap() error { return e.Err }
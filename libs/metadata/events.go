@@ -1,5 +1,7 @@
 package metadata
 
+import "time"
+
 type ChangeType int
 
 const (
@@ -7,17 +9,17 @@ const (
 	ChangeTypeDelete
 )
 
-const (
-	PlayerChangeEventKey = "player.change"
-	ServerChangeEventKey = "server.change"
-)
-
 // MetadataChangeEvent represents a change event for metadata.
+// Revision and Timestamp are those of the KV entry that caused the change
+// (the delete marker for deletes), so consumers can order events and discard
+// ones older than what they have already seen.
 type MetadataChangeEvent struct {
-	Key      string
-	OldValue *Metadata
-	NewValue *Metadata
-	Type     ChangeType
+	Key       string
+	OldValue  *Metadata
+	NewValue  *Metadata
+	Type      ChangeType
+	Revision  uint64
+	Timestamp time.Time
 }
 
 // Subscription callback type.
@@ -25,7 +27,47 @@ type MetadataChangeCallback func(event MetadataChangeEvent)
 
 // WatcherStatus holds watcher health.
 type WatcherStatus struct {
-	Watcher string // "players" or "servers"
+	Watcher string // kind name, e.g. "players" or "servers"
 	Healthy bool
-	Error   error
+	// Synced is set once the watcher has caught up with the bucket, i.e. after
+	// the initial values (or the changes missed while it was down) are applied.
+	Synced bool
+	// Revision is the last bucket revision applied to the cache.
+	Revision uint64
+	Error    error
+}
+
+// ConnectionWatcher is the WatcherStatus.Watcher name of the NATS connection.
+const ConnectionWatcher = "connection"
+
+// ConnectionEventType is what happened to the NATS connection.
+type ConnectionEventType int
+
+const (
+	ConnectionDisconnected ConnectionEventType = iota
+	ConnectionReconnected
+	// ConnectionClosed is final: the client no longer reconnects.
+	ConnectionClosed
+)
+
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionDisconnected:
+		return "disconnected"
+	case ConnectionReconnected:
+		return "reconnected"
+	case ConnectionClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionEvent reports a change of the NATS connection.
+type ConnectionEvent struct {
+	Type ConnectionEventType
+	// URL is the server reconnected to, for ConnectionReconnected.
+	URL string
+	// Error is the cause of a disconnect, when known.
+	Error error
 }
@@ -0,0 +1,369 @@
+package metadata
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+const (
+	// defaultCollectInterval is used when WithCollectInterval is not given.
+	defaultCollectInterval = 30 * time.Second
+	// garbageCollectorLease is the lease held by the running collector.
+	garbageCollectorLease = "garbage-collector"
+	// garbageCollectorIdentity is the changed-by annotation of its writes.
+	garbageCollectorIdentity = "garbage-collector"
+)
+
+// GarbageCollectorOption configures StartGarbageCollector.
+type GarbageCollectorOption func(*gcOptions)
+
+type gcOptions struct {
+	interval   time.Duration
+	holder     string
+	references []annotationReference
+}
+
+// annotationReference is an annotation of kind naming an object of target.
+type annotationReference struct {
+	kind   string
+	key    constant.AnnotationKey
+	target string
+}
+
+// WithCollectInterval sets how often the leader collects garbage. The default
+// is 30s.
+func WithCollectInterval(interval time.Duration) GarbageCollectorOption {
+	return func(o *gcOptions) { o.interval = interval }
+}
+
+// WithLeaseHolder sets the identity stored in the leader lease. It defaults to
+// the hostname and process ID.
+func WithLeaseHolder(holder string) GarbageCollectorOption {
+	return func(o *gcOptions) { o.holder = holder }
+}
+
+// WithReferenceAnnotation makes the collector remove the annotation key from
+// objects of kind when it names an object of target that no longer exists,
+// e.g. the current server of a player once that server is deleted. Unlike an
+// owner reference, the object itself is kept.
+func WithReferenceAnnotation(kind string, key constant.AnnotationKey, target string) GarbageCollectorOption {
+	return func(o *gcOptions) {
+		o.references = append(o.references, annotationReference{kind: kind, key: key, target: target})
+	}
+}
+
+// GarbageCollector deletes objects whose owners are gone and finishes the
+// foreground and orphan deletions recorded by Delete. Any number of clients
+// may run one: only the holder of the lease in Config.LocksBucket collects,
+// and another takes over once the lease expires.
+type GarbageCollector struct {
+	client *Client
+	opts   gcOptions
+	lease  *lease
+	leader atomic.Bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartGarbageCollector runs a garbage collector until Stop is called or ctx
+// ends. It fails with ErrLocksDisabled if Config.LocksBucket is empty.
+func (c *Client) StartGarbageCollector(ctx context.Context, opts ...GarbageCollectorOption) (*GarbageCollector, error) {
+	o := gcOptions{interval: defaultCollectInterval, holder: defaultLeaseHolder()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.interval <= 0 {
+		return nil, fmt.Errorf("invalid collect interval %v", o.interval)
+	}
+	for _, ref := range o.references {
+		for _, kind := range []string{ref.kind, ref.target} {
+			if _, ok := c.Store(kind); !ok {
+				return nil, fmt.Errorf("reference annotation %s: unknown resource kind %q", ref.key, kind)
+			}
+		}
+	}
+	backend, err := c.openLocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	gc := &GarbageCollector{
+		client: c,
+		opts:   o,
+		lease:  &lease{backend: backend, name: garbageCollectorLease, holder: o.holder},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go gc.run(ctx)
+	return gc, nil
+}
+
+// IsLeader reports whether this collector holds the lease.
+func (gc *GarbageCollector) IsLeader() bool {
+	return gc.leader.Load()
+}
+
+// Stop ends the collector and releases the lease if it holds it.
+func (gc *GarbageCollector) Stop(ctx context.Context) error {
+	gc.cancel()
+	<-gc.done
+	gc.leader.Store(false)
+	return gc.lease.release(ctx)
+}
+
+func (gc *GarbageCollector) run(ctx context.Context) {
+	c := gc.client
+	defer close(gc.done)
+	// Renew well within the TTL so a slow round-trip does not lose the lease.
+	renew := time.NewTicker(c.leaseTTL() / 3)
+	defer renew.Stop()
+	collect := time.NewTicker(gc.opts.interval)
+	defer collect.Stop()
+
+	gc.renew(ctx)
+	for {
+		select {
+		case <-renew.C:
+			gc.renew(ctx)
+		case <-collect.C:
+			if gc.IsLeader() {
+				gc.collect(ctx)
+			}
+		case <-ctx.Done():
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// renew acquires or renews the lease, and collects right away when it was
+// just acquired.
+func (gc *GarbageCollector) renew(ctx context.Context) {
+	c := gc.client
+	held, err := gc.lease.acquire(ctx)
+	if err != nil && ctx.Err() == nil {
+		c.logger.Warn("Failed to renew garbage collector lease", "error", err)
+	}
+	if gc.leader.Swap(held) == held {
+		return
+	}
+	if !held {
+		c.logger.Info("Lost garbage collector lease", "holder", gc.opts.holder)
+		return
+	}
+	c.logger.Info("Acquired garbage collector lease", "holder", gc.opts.holder)
+	gc.collect(ctx)
+}
+
+func (gc *GarbageCollector) collect(ctx context.Context) {
+	if err := gc.Collect(ctx); err != nil && ctx.Err() == nil {
+		gc.client.logger.Warn("Garbage collection failed", "error", err)
+	}
+}
+
+// Collect runs one collection over the caches of every registered kind,
+// whether or not this collector is the leader:
+//
+//   - an object whose owners are all gone is deleted, and one whose owners
+//     are all being deleted in the foreground is marked for a foreground
+//     deletion itself; otherwise the references to owners that are gone or
+//     being deleted are removed from it
+//   - an object marked for deletion is deleted once nothing references it
+//   - the reference annotations naming objects that are gone are removed
+//
+// Every write is conditional on the revision the decision was made on, and
+// a write that conflicts is left for the next collection. Deleting a
+// hierarchy takes one collection per level.
+func (gc *GarbageCollector) Collect(ctx context.Context) error {
+	c := gc.client
+	if !c.HasSynced() {
+		return nil
+	}
+	ctx = WithChangedBy(ctx, garbageCollectorIdentity)
+
+	objects := make(map[OwnerReference]*Metadata)
+	for _, s := range c.registeredStores() {
+		for key, m := range s.List() {
+			objects[OwnerReference{Kind: s.kind.Name, Key: key}] = m
+		}
+	}
+	dependents := make(map[OwnerReference]int)
+	for _, m := range objects {
+		for _, owner := range m.OwnerReferences {
+			dependents[owner]++
+		}
+	}
+
+	var errs []error
+	for _, ref := range slices.SortedFunc(maps.Keys(objects), compareRefs) {
+		m := objects[ref]
+		var err error
+		switch {
+		case m.Deletion != nil:
+			if dependents[ref] == 0 {
+				err = gc.finishDeletion(ctx, ref, m)
+			}
+		case len(m.OwnerReferences) > 0:
+			err = gc.collectDependent(ctx, ref, m, objects)
+		}
+		errs = append(errs, ignoreStale(err))
+	}
+	for _, ann := range gc.opts.references {
+		errs = append(errs, gc.collectReferences(ctx, ann, objects))
+	}
+	return errors.Join(errs...)
+}
+
+func compareRefs(a, b OwnerReference) int {
+	return cmp.Or(strings.Compare(a.Kind, b.Kind), strings.Compare(a.Key, b.Key))
+}
+
+// ignoreStale drops the errors of writes based on a value that changed since,
+// which the next collection sees anew.
+func ignoreStale(err error) error {
+	if errors.Is(err, ErrConflict) || errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ownerState is what became of an owner.
+type ownerState int
+
+const (
+	ownerPresent ownerState = iota
+	ownerGone
+	ownerDeletingForeground
+	ownerDeletingOrphan
+)
+
+// ownerState looks owner up in the caches, and in its bucket if it is not
+// cached, since a missing owner must not be mistaken for a cache lagging
+// behind. Owners of unregistered kinds cannot be checked and count as present.
+func (gc *GarbageCollector) ownerState(ctx context.Context, owner OwnerReference, objects map[OwnerReference]*Metadata) (ownerState, error) {
+	m, ok := objects[owner]
+	if !ok {
+		s, registered := gc.client.Store(owner.Kind)
+		if !registered {
+			return ownerPresent, nil
+		}
+		var err error
+		if m, err = s.load(ctx, owner.Key); err != nil {
+			return 0, err
+		}
+		if m == nil {
+			return ownerGone, nil
+		}
+	}
+	switch {
+	case m.Deletion == nil:
+		return ownerPresent, nil
+	case m.Deletion.Policy == DeleteOrphan:
+		return ownerDeletingOrphan, nil
+	default:
+		return ownerDeletingForeground, nil
+	}
+}
+
+// collectDependent deletes, marks or trims the owner references of an
+// object, as described on Collect.
+func (gc *GarbageCollector) collectDependent(ctx context.Context, ref OwnerReference, m *Metadata, objects map[OwnerReference]*Metadata) error {
+	c := gc.client
+	var (
+		present, gone, foreground bool
+		stale                     []OwnerReference
+	)
+	for _, owner := range m.OwnerReferences {
+		state, err := gc.ownerState(ctx, owner, objects)
+		if err != nil {
+			return fmt.Errorf("failed to check owner %s of %s: %w", owner, ref, err)
+		}
+		switch state {
+		case ownerPresent:
+			present = true
+			continue
+		case ownerGone:
+			gone = true
+		case ownerDeletingForeground:
+			foreground = true
+		}
+		stale = append(stale, owner)
+	}
+	s, _ := c.Store(ref.Kind)
+
+	switch {
+	case len(stale) == 0:
+		return nil
+	case !present && foreground:
+		c.logger.Info("Deleting dependent in the foreground", "kind", ref.Kind, "key", ref.Key)
+		return s.markDeletion(ctx, ref.Key, deleteOptions{revision: m.Revision, propagation: DeleteForeground})
+	case !present && gone:
+		c.logger.Info("Deleting dependent", "kind", ref.Kind, "key", ref.Key)
+		return s.DeleteContext(ctx, ref.Key, WithRevision(m.Revision))
+	default:
+		// Some owner is still there, or the others orphan it.
+		c.logger.Info("Removing owner references", "kind", ref.Kind, "key", ref.Key, "owners", stale)
+		_, err := s.modify(ctx, ref.Key, m.Revision, func(next *Metadata) error {
+			for _, owner := range stale {
+				next.RemoveOwner(owner.Kind, owner.Key)
+			}
+			return nil
+		})
+		return err
+	}
+}
+
+// finishDeletion deletes an object marked by Delete once nothing references
+// it anymore.
+func (gc *GarbageCollector) finishDeletion(ctx context.Context, ref OwnerReference, m *Metadata) error {
+	s, _ := gc.client.Store(ref.Kind)
+	opts := []DeleteOption{WithRevision(m.Revision)}
+	if m.Deletion.Purge {
+		opts = append(opts, WithPurge())
+	}
+	gc.client.logger.Info("Finishing deletion", "kind", ref.Kind, "key", ref.Key, "policy", m.Deletion.Policy)
+	return s.DeleteContext(ctx, ref.Key, opts...)
+}
+
+// collectReferences removes the reference annotations of ann that name
+// objects that are gone.
+func (gc *GarbageCollector) collectReferences(ctx context.Context, ann annotationReference, objects map[OwnerReference]*Metadata) error {
+	s, _ := gc.client.Store(ann.kind)
+	var errs []error
+	for ref, m := range objects {
+		if ref.Kind != ann.kind {
+			continue
+		}
+		value, ok := m.GetAnnotation(ann.key)
+		if !ok || value == "" {
+			continue
+		}
+		state, err := gc.ownerState(ctx, OwnerReference{Kind: ann.target, Key: value}, objects)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to check %s of %s: %w", ann.key, ref, err))
+			continue
+		}
+		if state != ownerGone {
+			continue
+		}
+		gc.client.logger.Info("Removing reference annotation", "kind", ref.Kind, "key", ref.Key, "annotation", ann.key, "value", value)
+		_, err = s.modify(ctx, ref.Key, m.Revision, func(next *Metadata) error {
+			next.DeleteAnnotation(ann.key)
+			return nil
+		})
+		errs = append(errs, ignoreStale(err))
+	}
+	return errors.Join(errs...)
+}
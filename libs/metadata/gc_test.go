@@ -0,0 +1,248 @@
+package metadata_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+// newWorldsClient returns a client with a "worlds" kind whose objects are
+// owned by servers, and a collector that only runs when Collect is called.
+func newWorldsClient(t *testing.T, opts ...metadata.GarbageCollectorOption) (*metadata.Client, *metadata.Store, *metadata.GarbageCollector) {
+	t.Helper()
+	client := metadatatest.NewMemoryClient(t)
+	worlds, err := client.RegisterKind(metadata.ResourceKind{Name: "worlds", Bucket: "worlds"})
+	if err != nil {
+		t.Fatalf("RegisterKind failed: %v", err)
+	}
+	if err := worlds.WaitForSync(context.Background()); err != nil {
+		t.Fatalf("WaitForSync failed: %v", err)
+	}
+	gc, err := client.StartGarbageCollector(context.Background(), append(opts, metadata.WithCollectInterval(time.Hour))...)
+	if err != nil {
+		t.Fatalf("StartGarbageCollector failed: %v", err)
+	}
+	t.Cleanup(func() { gc.Stop(context.Background()) })
+	return client, worlds, gc
+}
+
+func createOwned(t *testing.T, s *metadata.Store, key string, owners ...metadata.OwnerReference) {
+	t.Helper()
+	if _, err := s.UpdateAndWait(context.Background(), key, func(m *metadata.Metadata) {
+		for _, owner := range owners {
+			m.SetOwner(owner.Kind, owner.Key)
+		}
+	}); err != nil {
+		t.Fatalf("failed to create %s: %v", key, err)
+	}
+}
+
+// collectUntil runs collections until cond holds, since each one only sees
+// what the caches have applied so far.
+func collectUntil(t *testing.T, gc *metadata.GarbageCollector, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if err := gc.Collect(context.Background()); err != nil {
+			t.Fatalf("Collect failed: %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGarbageCollectorBackground(t *testing.T) {
+	client, worlds, gc := newWorldsClient(t)
+	lobby := metadata.OwnerReference{Kind: metadata.KindServers, Key: "lobby"}
+	hub := metadata.OwnerReference{Kind: metadata.KindServers, Key: "hub"}
+	for _, name := range []string{"lobby", "hub"} {
+		setServerLabel(t, client, name, "mode", name)
+	}
+	createOwned(t, worlds, "lobby-world", lobby)
+	createOwned(t, worlds, "shared-world", lobby, hub)
+
+	if err := gc.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if _, ok := worlds.Get("lobby-world"); !ok {
+		t.Fatalf("a dependent of a live owner must be kept")
+	}
+
+	if err := client.DeleteServer("lobby"); err != nil {
+		t.Fatalf("DeleteServer failed: %v", err)
+	}
+	collectUntil(t, gc, "lobby-world to be deleted", func() bool {
+		_, ok := worlds.Get("lobby-world")
+		return !ok
+	})
+	collectUntil(t, gc, "shared-world to lose its lobby owner", func() bool {
+		m, _ := worlds.Get("shared-world")
+		return !m.IsOwnedBy(lobby.Kind, lobby.Key)
+	})
+	m, _ := worlds.Get("shared-world")
+	if !m.IsOwnedBy(hub.Kind, hub.Key) || !m.HasAnnotation(constant.ChangedBy, "garbage-collector") {
+		t.Fatalf("expected the hub owner to stay, got %+v", m)
+	}
+}
+
+func TestGarbageCollectorForeground(t *testing.T) {
+	client, worlds, gc := newWorldsClient(t)
+	setServerLabel(t, client, "lobby", "mode", "lobby")
+	createOwned(t, worlds, "lobby-world", metadata.OwnerReference{Kind: metadata.KindServers, Key: "lobby"})
+	createOwned(t, worlds, "lobby-nether", metadata.OwnerReference{Kind: "worlds", Key: "lobby-world"})
+
+	if err := client.DeleteServer("lobby", metadata.WithPropagation(metadata.DeleteForeground), metadata.WithPurge()); err != nil {
+		t.Fatalf("DeleteServer failed: %v", err)
+	}
+	m, err := client.Servers().UpdateAndWait(context.Background(), "lobby", func(m *metadata.Metadata) { m.Deletion = nil })
+	if err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if m.Deletion == nil || m.Deletion.Policy != metadata.DeleteForeground || !m.Deletion.Purge {
+		t.Fatalf("expected a pending foreground deletion kept by updates, got %+v", m.Deletion)
+	}
+
+	collectUntil(t, gc, "lobby-world to be marked", func() bool {
+		m, _ := worlds.Get("lobby-world")
+		return m.Deletion != nil
+	})
+	if _, ok := client.GetServer("lobby"); !ok {
+		t.Fatalf("the owner must outlive its dependents")
+	}
+	collectUntil(t, gc, "lobby to be deleted", func() bool {
+		_, ok := client.GetServer("lobby")
+		return !ok
+	})
+	for _, key := range []string{"lobby-world", "lobby-nether"} {
+		if _, ok := worlds.Get(key); ok {
+			t.Fatalf("expected %s to be deleted before its owner", key)
+		}
+	}
+	if history, err := client.ServerHistory(context.Background(), "lobby"); err != nil || len(history) != 1 {
+		t.Fatalf("expected the purge to drop the history of lobby, got %d entries (%v)", len(history), err)
+	}
+}
+
+func TestGarbageCollectorOrphan(t *testing.T) {
+	client, worlds, gc := newWorldsClient(t)
+	setServerLabel(t, client, "lobby", "mode", "lobby")
+	createOwned(t, worlds, "lobby-world", metadata.OwnerReference{Kind: metadata.KindServers, Key: "lobby"})
+
+	if err := client.DeleteServer("lobby", metadata.WithPropagation(metadata.DeleteOrphan)); err != nil {
+		t.Fatalf("DeleteServer failed: %v", err)
+	}
+	collectUntil(t, gc, "lobby to be deleted", func() bool {
+		_, ok := client.GetServer("lobby")
+		return !ok
+	})
+	m, ok := worlds.Get("lobby-world")
+	if !ok || len(m.OwnerReferences) != 0 {
+		t.Fatalf("expected lobby-world to be orphaned, got %+v", m)
+	}
+}
+
+func TestGarbageCollectorReferenceAnnotation(t *testing.T) {
+	const uuid = "8f0c2a4e-0000-4000-8000-000000000001"
+	client, _, gc := newWorldsClient(t,
+		metadata.WithReferenceAnnotation(metadata.KindPlayers, constant.PlayerCurrentServer, metadata.KindServers))
+	setServerLabel(t, client, "lobby", "mode", "lobby")
+	if _, err := client.Players().UpdateAndWait(context.Background(), uuid, func(m *metadata.Metadata) {
+		m.SetAnnotation(constant.PlayerCurrentServer, "lobby")
+	}); err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+
+	if err := client.DeleteServer("lobby"); err != nil {
+		t.Fatalf("DeleteServer failed: %v", err)
+	}
+	collectUntil(t, gc, "current_server to be cleared", func() bool {
+		m, _ := client.GetPlayer(uuid)
+		_, ok := m.GetAnnotation(constant.PlayerCurrentServer)
+		return !ok
+	})
+}
+
+func TestGarbageCollectorLeaderLease(t *testing.T) {
+	ctx := context.Background()
+	storage := metadata.NewMemoryStorage()
+	cfg := metadatatest.NewConfig("")
+	cfg.LeaseTTL = 300 * time.Millisecond
+	first := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+	second := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+
+	start := func(client *metadata.Client, holder string) *metadata.GarbageCollector {
+		gc, err := client.StartGarbageCollector(ctx, metadata.WithLeaseHolder(holder), metadata.WithCollectInterval(20*time.Millisecond))
+		if err != nil {
+			t.Fatalf("StartGarbageCollector failed: %v", err)
+		}
+		t.Cleanup(func() { gc.Stop(ctx) })
+		return gc
+	}
+	leader := start(first, "first")
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("the first collector to lead", leader.IsLeader)
+	follower := start(second, "second")
+	time.Sleep(150 * time.Millisecond)
+	if follower.IsLeader() {
+		t.Fatalf("only one collector may hold the lease")
+	}
+
+	if err := leader.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	waitFor("the second collector to take over", follower.IsLeader)
+
+	// The leader collects on its own.
+	setServerLabel(t, second, "lobby", "mode", "lobby")
+	if _, err := second.Players().UpdateAndWait(ctx, "8f0c2a4e-0000-4000-8000-000000000001", func(m *metadata.Metadata) {
+		m.SetOwner(metadata.KindServers, "lobby")
+	}); err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if err := second.DeleteServer("lobby"); err != nil {
+		t.Fatalf("DeleteServer failed: %v", err)
+	}
+	waitFor("the owned player to be collected", func() bool {
+		_, ok := second.GetPlayer("8f0c2a4e-0000-4000-8000-000000000001")
+		return !ok
+	})
+}
+
+func TestOwnerReferencesBumpGeneration(t *testing.T) {
+	client := metadatatest.NewMemoryClient(t)
+	if _, err := client.Servers().UpdateAndWait(context.Background(), "lobby-world", func(m *metadata.Metadata) {}); err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	m, err := client.Servers().UpdateAndWait(context.Background(), "lobby-world", func(m *metadata.Metadata) {
+		m.SetOwner(metadata.KindServers, "lobby")
+		m.SetOwner(metadata.KindServers, "lobby")
+	})
+	if err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if m.Generation != 2 || len(m.OwnerReferences) != 1 {
+		t.Fatalf("expected one owner reference in generation 2, got %+v", m)
+	}
+	history, err := client.ServerHistory(context.Background(), "lobby-world")
+	if err != nil {
+		t.Fatalf("ServerHistory failed: %v", err)
+	}
+	if d := history[len(history)-1].Diff; len(d.Owners) != 1 || d.Owners[0].Key != "servers/lobby" || d.Owners[0].Type != metadata.FieldAdded {
+		t.Fatalf("expected the owner in the diff, got %+v", d)
+	}
+}
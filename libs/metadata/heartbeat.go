@@ -0,0 +1,466 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// defaultHeartbeatTTL is used when Config.HeartbeatTTL is not set.
+const defaultHeartbeatTTL = 15 * time.Second
+
+// heartbeatsWatcher names the heartbeat watcher in WatcherStatus updates.
+const heartbeatsWatcher = "heartbeats"
+
+// ConditionOnline is the server condition kept by the heartbeat helpers: True
+// while a Heartbeater runs, False once it is stopped or its heartbeat lapses.
+const ConditionOnline = "Online"
+
+// Reasons of the Online condition.
+const (
+	ReasonHeartbeating  = "Heartbeating"
+	ReasonStopped       = "Stopped"
+	ReasonHeartbeatLost = "HeartbeatLost"
+)
+
+// ServerState returns the state of a server from its Online condition. ok is
+// false for servers no heartbeat helper has reported on.
+func ServerState(m *Metadata) (state constant.ServerState, ok bool) {
+	c, ok := m.Status.GetCondition(ConditionOnline)
+	if !ok {
+		return "", false
+	}
+	if c.Status == ConditionTrue {
+		return constant.ServerOnline, true
+	}
+	return constant.ServerOffline, true
+}
+
+// errNotOnline aborts markOffline on servers that are not online.
+var errNotOnline = errors.New("server not online")
+
+// ErrHeartbeatsDisabled is returned by the heartbeat helpers when
+// Config.HeartbeatsBucket is empty.
+var ErrHeartbeatsDisabled = errors.New("heartbeats are disabled")
+
+// ServerLostEvent is emitted when a server stops sending heartbeats without
+// stopping its Heartbeater.
+type ServerLostEvent struct {
+	Server string
+	// LastHeartbeat is zero for servers whose heartbeat expired before this
+	// client started watching.
+	LastHeartbeat time.Time
+}
+
+// ServerLostCallback receives ServerLostEvents. It runs on the client's
+// liveness goroutine and should not block.
+type ServerLostCallback func(ServerLostEvent)
+
+// heartbeat is the value stored in the heartbeats bucket.
+type heartbeat struct {
+	Server string    `json:"server"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// heartbeatTTL is the TTL of the heartbeats bucket once it is open, and
+// Config.HeartbeatTTL until then.
+func (c *Client) heartbeatTTL() time.Duration {
+	if c.liveness != nil {
+		return c.liveness.ttl
+	}
+	if ttl := c.config.HeartbeatTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultHeartbeatTTL
+}
+
+// SendHeartbeat records that server is alive. The entry expires from the
+// heartbeats bucket after the bucket's TTL unless it is sent again.
+func (c *Client) SendHeartbeat(ctx context.Context, server string) error {
+	if c.liveness == nil {
+		return ErrHeartbeatsDisabled
+	}
+	data, err := json.Marshal(heartbeat{Server: server, SentAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if _, err := c.liveness.backend.Put(ctx, server, data); err != nil {
+		return fmt.Errorf("failed to send heartbeat for %s: %w", server, err)
+	}
+	return nil
+}
+
+// LastHeartbeat returns when this client last received a heartbeat from
+// server. ok is false for servers with no live heartbeat.
+func (c *Client) LastHeartbeat(server string) (t time.Time, ok bool) {
+	if c.liveness == nil {
+		return time.Time{}, false
+	}
+	c.liveness.mu.Lock()
+	defer c.liveness.mu.Unlock()
+	t, ok = c.liveness.lastSeen[server]
+	return t, ok
+}
+
+// SubscribeToServerLost registers a callback for servers whose heartbeat lapsed.
+func (c *Client) SubscribeToServerLost(cb ServerLostCallback) (unsubscribe func()) {
+	if c.liveness == nil {
+		return func() {}
+	}
+	l := c.liveness
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := l.nextSub
+	l.nextSub++
+	l.subs[id] = cb
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs, id)
+	}
+}
+
+// Heartbeater keeps a server's heartbeat alive until Stop is called.
+type Heartbeater struct {
+	client *Client
+	server string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartHeartbeat marks server online (see ConditionOnline) and sends a heartbeat every interval
+// until Stop is called or ctx ends. A zero interval sends three heartbeats per
+// TTL. The first heartbeat is sent before StartHeartbeat returns.
+func (c *Client) StartHeartbeat(ctx context.Context, server string, interval time.Duration) (*Heartbeater, error) {
+	if interval <= 0 {
+		interval = c.heartbeatTTL() / 3
+	}
+	if err := c.SendHeartbeat(ctx, server); err != nil {
+		return nil, err
+	}
+	if err := c.markOnline(ctx, server); err != nil {
+		return nil, fmt.Errorf("failed to mark %s online: %w", server, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	h := &Heartbeater{client: c, server: server, cancel: cancel, done: make(chan struct{})}
+	go h.run(ctx, interval)
+	return h, nil
+}
+
+func (h *Heartbeater) run(ctx context.Context, interval time.Duration) {
+	c := h.client
+	defer close(h.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.SendHeartbeat(ctx, h.server); err != nil && ctx.Err() == nil {
+				c.logger.Warn("Failed to send heartbeat", "server", h.server, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop ends the heartbeat, removes it from the bucket and marks the server
+// offline. Other clients see a graceful stop, not a lost server.
+func (h *Heartbeater) Stop(ctx context.Context) error {
+	h.cancel()
+	<-h.done
+
+	c := h.client
+	if err := c.liveness.backend.Delete(ctx, h.server, 0); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to remove heartbeat for %s: %w", h.server, err)
+	}
+	if err := c.markOffline(ctx, h.server, ReasonStopped); err != nil {
+		return fmt.Errorf("failed to mark %s offline: %w", h.server, err)
+	}
+	return nil
+}
+
+// markOnline sets the Online condition of server, creating the server if it
+// does not exist yet.
+func (c *Client) markOnline(ctx context.Context, server string) error {
+	online := func(s *Status) {
+		s.SetCondition(Condition{Type: ConditionOnline, Status: ConditionTrue, Reason: ReasonHeartbeating})
+	}
+	_, err := c.UpdateServerStatus(ctx, server, online)
+	if errors.Is(err, ErrNotFound) {
+		// Status writes do not create objects.
+		if err = c.UpdateServerContext(ctx, server, func(*Metadata) {}); err == nil {
+			_, err = c.UpdateServerStatus(ctx, server, online)
+		}
+	}
+	return err
+}
+
+// markOffline flips the Online condition of server to False. It only writes
+// when the server is online, so that every client noticing a lost server does
+// not add a revision of its own, and does nothing for deleted servers.
+func (c *Client) markOffline(ctx context.Context, server, reason string) error {
+	_, err := c.servers.write(ctx, server, 0, false, func(current, next *Metadata) error {
+		if !current.Status.IsConditionTrue(ConditionOnline) {
+			return errNotOnline
+		}
+		next.Status.SetCondition(Condition{Type: ConditionOnline, Status: ConditionFalse, Reason: reason})
+		stampChangedBy(ctx, c.config, next)
+		return nil
+	})
+	if errors.Is(err, errNotOnline) || errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// liveness watches the heartbeats bucket and reports servers whose heartbeat
+// lapsed. Expiry in the bucket itself is silent, so lapses are found by
+// comparing the time since the last heartbeat was received with the TTL.
+type liveness struct {
+	client  *Client
+	backend Backend
+	ttl     time.Duration
+
+	mu       sync.Mutex
+	revision uint64
+	lastSeen map[string]time.Time
+	subs     map[int]ServerLostCallback
+	nextSub  int
+}
+
+// ttlBackend is implemented by backends that can report the TTL of their
+// bucket.
+type ttlBackend interface {
+	bucketTTL(ctx context.Context) (time.Duration, error)
+}
+
+// startLiveness opens the heartbeats bucket and starts tracking heartbeats.
+// It does nothing when Config.HeartbeatsBucket is empty, or when the bucket is
+// missing and the client does not reconcile buckets.
+func (c *Client) startLiveness() error {
+	bucket := c.config.HeartbeatsBucket
+	if bucket == "" {
+		return nil
+	}
+	ttl := c.heartbeatTTL()
+	// Heartbeats only need the latest value and expire on their own TTL. An
+	// existing bucket is used as it is: clients with different HeartbeatTTLs
+	// would otherwise keep rewriting its TTL.
+	cfg := c.bucketConfig(bucket)
+	cfg.History, cfg.TTL = 1, ttl
+	reconcile := cfg.Reconcile
+	cfg.Reconcile = false
+	backend, err := c.storage.Open(c.ctx, cfg)
+	if errors.Is(err, ErrBucketNotFound) {
+		if !reconcile {
+			c.logger.Warn("Heartbeats bucket not found, lost-server detection is disabled", "bucket", bucket)
+			return nil
+		}
+		cfg.Reconcile = true
+		backend, err = c.storage.Open(c.ctx, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to initialize heartbeats KV: %w", err)
+	}
+	if b, ok := backend.(ttlBackend); ok {
+		bucketTTL, err := b.bucketTTL(c.ctx)
+		if err != nil {
+			return fmt.Errorf("failed to initialize heartbeats KV: %w", err)
+		}
+		if bucketTTL != ttl {
+			c.logger.Warn("Heartbeats bucket TTL differs from HeartbeatTTL, using the bucket's",
+				"bucket", bucket, "bucket_ttl", bucketTTL, "heartbeat_ttl", ttl)
+			if bucketTTL > 0 {
+				ttl = bucketTTL
+			}
+		}
+	}
+	c.liveness = &liveness{
+		client:   c,
+		backend:  backend,
+		ttl:      ttl,
+		lastSeen: make(map[string]time.Time),
+		subs:     make(map[int]ServerLostCallback),
+	}
+
+	c.wg.Add(2)
+	go c.liveness.watch()
+	go c.liveness.monitor()
+	return nil
+}
+
+// watch follows the heartbeats bucket until the client is closed, resuming
+// after the last applied revision when the watcher fails.
+func (l *liveness) watch() {
+	c := l.client
+	defer c.wg.Done()
+	for {
+		err := l.watchOnce()
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.logger.Error("Watcher failed", "kind", heartbeatsWatcher, "error", err)
+		c.reportWatcherStatus(WatcherStatus{Watcher: heartbeatsWatcher, Healthy: false, Revision: l.lastRevision(), Error: err})
+		select {
+		case <-time.After(watchRetryDelay):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *liveness) watchOnce() error {
+	c := l.client
+
+	var from uint64
+	if rev := l.lastRevision(); rev > 0 {
+		from = rev + 1
+	}
+	watcher, err := l.backend.Watch(c.ctx, from)
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+	c.reportWatcherStatus(WatcherStatus{Watcher: heartbeatsWatcher, Healthy: true, Revision: l.lastRevision()})
+
+	for {
+		select {
+		case entry, ok := <-watcher.Updates():
+			if !ok {
+				return errWatcherClosed
+			}
+			if entry == nil {
+				c.reportWatcherStatus(WatcherStatus{Watcher: heartbeatsWatcher, Healthy: true, Synced: true, Revision: l.lastRevision()})
+				c.wg.Add(1)
+				go l.sweep()
+				continue
+			}
+			l.apply(entry)
+
+		case <-c.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (l *liveness) lastRevision() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.revision
+}
+
+func (l *liveness) apply(entry *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if entry.Revision <= l.revision {
+		return
+	}
+	l.revision = entry.Revision
+	if entry.Operation != EntryPut {
+		// Graceful stop: forget the server without reporting it lost.
+		delete(l.lastSeen, entry.Key)
+		return
+	}
+	// Heartbeats are timed from their receipt, not from entry.Created: the
+	// server's clock may differ from ours.
+	l.lastSeen[entry.Key] = time.Now()
+}
+
+// sweep reports the servers marked online that have no live heartbeat, such as
+// a server whose heartbeat expired while no client was running: only the
+// heartbeats seen by the watcher are expired by monitor. It runs once the
+// heartbeats watcher has synced, and waits for the servers cache.
+func (l *liveness) sweep() {
+	c := l.client
+	defer c.wg.Done()
+	if err := c.servers.WaitForSync(c.ctx); err != nil {
+		return
+	}
+	for name, m := range c.servers.List() {
+		if !m.Status.IsConditionTrue(ConditionOnline) {
+			continue
+		}
+		if _, ok := c.LastHeartbeat(name); ok {
+			continue
+		}
+		// The watcher may not have applied a heartbeat sent since it synced.
+		_, err := l.backend.Get(c.ctx, name)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrNotFound) {
+			if c.ctx.Err() == nil {
+				c.logger.Warn("Failed to check heartbeat", "server", name, "error", err)
+			}
+			continue
+		}
+		l.lost(ServerLostEvent{Server: name})
+	}
+}
+
+// monitor checks for lapsed heartbeats several times per TTL.
+func (l *liveness) monitor() {
+	c := l.client
+	defer c.wg.Done()
+	ticker := time.NewTicker(l.ttl / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, event := range l.expire(time.Now()) {
+				l.lost(event)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// expire forgets every server whose last heartbeat is older than the TTL and
+// returns them.
+func (l *liveness) expire(now time.Time) []ServerLostEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var lost []ServerLostEvent
+	for server, seen := range l.lastSeen {
+		if now.Sub(seen) > l.ttl {
+			delete(l.lastSeen, server)
+			lost = append(lost, ServerLostEvent{Server: server, LastHeartbeat: seen})
+		}
+	}
+	return lost
+}
+
+// lost notifies subscribers and flips the server's Online condition to False.
+// Every client does this, so only the first one to get there writes.
+func (l *liveness) lost(event ServerLostEvent) {
+	c := l.client
+	c.logger.Warn("Server heartbeat lapsed", "server", event.Server, "last_heartbeat", event.LastHeartbeat)
+
+	l.mu.Lock()
+	subs := make([]ServerLostCallback, 0, len(l.subs))
+	for _, cb := range l.subs {
+		subs = append(subs, cb)
+	}
+	l.mu.Unlock()
+	for _, cb := range subs {
+		cb(event)
+	}
+
+	m, exists := c.servers.Get(event.Server)
+	if !exists || !m.Status.IsConditionTrue(ConditionOnline) {
+		return
+	}
+	if err := c.markOffline(c.ctx, event.Server, ReasonHeartbeatLost); err != nil && c.ctx.Err() == nil {
+		c.logger.Warn("Failed to mark lost server offline", "server", event.Server, "error", err)
+	}
+}
@@ -0,0 +1,154 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func newHeartbeatClient(t *testing.T) *metadata.Client {
+	t.Helper()
+	cfg := metadatatest.NewConfig("")
+	cfg.HeartbeatTTL = 200 * time.Millisecond
+	return metadatatest.NewMemoryClientWithConfig(t, metadata.NewMemoryStorage(), cfg)
+}
+
+func waitForStatus(t *testing.T, client *metadata.Client, server string, want constant.ServerState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if m, ok := client.GetServer(server); ok {
+			if state, ok := metadata.ServerState(m); ok && state == want {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be %s", server, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLapsedHeartbeatReportsServerLost(t *testing.T) {
+	client := newHeartbeatClient(t)
+
+	lost := make(chan metadata.ServerLostEvent, 1)
+	unsub := client.SubscribeToServerLost(func(e metadata.ServerLostEvent) { lost <- e })
+	defer unsub()
+
+	// Cancelling the context stops the heartbeats without Stop, like a crash.
+	ctx, crash := context.WithCancel(context.Background())
+	if _, err := client.StartHeartbeat(ctx, "lobby", 50*time.Millisecond); err != nil {
+		t.Fatalf("StartHeartbeat failed: %v", err)
+	}
+	waitForStatus(t, client, "lobby", constant.ServerOnline)
+	crash()
+
+	select {
+	case e := <-lost:
+		if e.Server != "lobby" || e.LastHeartbeat.IsZero() {
+			t.Fatalf("unexpected lost event %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the server lost event")
+	}
+	waitForStatus(t, client, "lobby", constant.ServerOffline)
+	m, _ := client.GetServer("lobby")
+	if c, _ := m.Status.GetCondition(metadata.ConditionOnline); c.Reason != metadata.ReasonHeartbeatLost || len(m.Annotations) != 0 {
+		t.Fatalf("expected only the Online condition to report the lost server, got %+v", m)
+	}
+	if _, ok := client.LastHeartbeat("lobby"); ok {
+		t.Fatalf("expected no live heartbeat for a lost server")
+	}
+}
+
+func TestHeartbeatExpiredBeforeClientStarted(t *testing.T) {
+	storage := metadata.NewMemoryStorage()
+	cfg := metadatatest.NewConfig("")
+	cfg.HeartbeatTTL = 200 * time.Millisecond
+
+	// The game server crashes and no other client is running to notice.
+	gameServer := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+	if _, err := gameServer.StartHeartbeat(context.Background(), "lobby", time.Hour); err != nil {
+		t.Fatalf("StartHeartbeat failed: %v", err)
+	}
+	gameServer.Close()
+	time.Sleep(2 * cfg.HeartbeatTTL)
+
+	client := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+	waitForStatus(t, client, "lobby", constant.ServerOffline)
+	m, _ := client.GetServer("lobby")
+	if c, _ := m.Status.GetCondition(metadata.ConditionOnline); c.Reason != metadata.ReasonHeartbeatLost {
+		t.Fatalf("expected the expired heartbeat to be reported lost, got %+v", c)
+	}
+}
+
+func TestStoppedHeartbeatIsNotLost(t *testing.T) {
+	client := newHeartbeatClient(t)
+
+	lost := make(chan metadata.ServerLostEvent, 1)
+	unsub := client.SubscribeToServerLost(func(e metadata.ServerLostEvent) { lost <- e })
+	defer unsub()
+
+	h, err := client.StartHeartbeat(context.Background(), "lobby", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartHeartbeat failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := client.LastHeartbeat("lobby"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the first heartbeat")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := h.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	waitForStatus(t, client, "lobby", constant.ServerOffline)
+
+	select {
+	case e := <-lost:
+		t.Fatalf("unexpected lost event after a graceful stop: %+v", e)
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
+func TestMissingHeartbeatsBucketDisablesLiveness(t *testing.T) {
+	storage := metadata.NewMemoryStorage()
+	cfg := metadatatest.NewConfig("")
+	cfg.HeartbeatsBucket = ""
+	metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+
+	cfg = metadatatest.NewConfig("")
+	cfg.ReconcileBuckets = false
+	client := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+	if err := client.SendHeartbeat(context.Background(), "lobby"); !errors.Is(err, metadata.ErrHeartbeatsDisabled) {
+		t.Fatalf("expected ErrHeartbeatsDisabled without a heartbeats bucket, got %v", err)
+	}
+}
+
+func TestHeartbeatsBucketTTLIsKept(t *testing.T) {
+	s := metadatatest.RunServer(t)
+	metadatatest.NewClientForServer(t, s)
+
+	cfg := metadatatest.NewConfig(s.ClientURL())
+	cfg.HeartbeatTTL = time.Minute
+	metadatatest.NewClientWithConfig(t, cfg)
+
+	status, err := openKV(t, s, "heartbeats").Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.TTL() != 15*time.Second {
+		t.Fatalf("expected the heartbeats bucket to keep its TTL of 15s, got %s", status.TTL())
+	}
+}
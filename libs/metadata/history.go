@@ -0,0 +1,190 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// FieldChangeType tells how a label, annotation, condition or owner reference
+// changed between revisions.
+type FieldChangeType int
+
+const (
+	FieldAdded FieldChangeType = iota
+	FieldRemoved
+	FieldChanged
+)
+
+func (t FieldChangeType) String() string {
+	switch t {
+	case FieldAdded:
+		return "added"
+	case FieldRemoved:
+		return "removed"
+	case FieldChanged:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+// FieldChange is one label, annotation, condition or owner reference that
+// differs between two revisions. OldValue is empty for FieldAdded and NewValue
+// for FieldRemoved. For conditions, Key is the condition type and the values
+// are its status followed by the reason in parentheses, e.g. "False
+// (WorldLoading)". For owner references, Key is the owner as "kind/key" and
+// the values are empty.
+type FieldChange struct {
+	Key      string
+	Type     FieldChangeType
+	OldValue string
+	NewValue string
+}
+
+// Diff lists the label, annotation, status condition and owner reference
+// changes of a revision, sorted by key.
+type Diff struct {
+	Labels      []FieldChange
+	Annotations []FieldChange
+	Conditions  []FieldChange
+	Owners      []FieldChange
+}
+
+// IsEmpty reports whether nothing changed.
+func (d Diff) IsEmpty() bool {
+	return len(d.Labels) == 0 && len(d.Annotations) == 0 && len(d.Conditions) == 0 && len(d.Owners) == 0
+}
+
+// HistoryEntry is one stored revision of an object.
+type HistoryEntry struct {
+	Revision  uint64
+	Type      ChangeType
+	Timestamp time.Time
+	// ChangedBy is the changed-by annotation of the revision (see
+	// Config.ChangedBy). Deletes carry no value, so it is empty for them.
+	ChangedBy string
+	// Value is the object at this revision, nil for deletes.
+	Value *Metadata
+	// Diff is relative to the previous revision in the history. The oldest
+	// revision kept, and the first one after a delete, are compared to an
+	// empty object.
+	Diff Diff
+}
+
+// PlayerHistory returns the stored revisions of a player, oldest first.
+func (c *Client) PlayerHistory(ctx context.Context, uuid string) ([]HistoryEntry, error) {
+	return c.players.History(ctx, uuid)
+}
+
+// ServerHistory returns the stored revisions of a server, oldest first.
+func (c *Client) ServerHistory(ctx context.Context, name string) ([]HistoryEntry, error) {
+	return c.servers.History(ctx, name)
+}
+
+// History returns the revisions of key kept by the bucket, oldest first, each
+// with its diff to the previous one. How many are kept is set by
+// Config.BucketHistory. ErrNotFound is returned if the bucket has none.
+func (s *Store) History(ctx context.Context, key string) ([]HistoryEntry, error) {
+	entries, err := s.backend.History(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]HistoryEntry, 0, len(entries))
+	var previous *Metadata
+	for _, entry := range entries {
+		h := HistoryEntry{Revision: entry.Revision, Timestamp: entry.Created}
+		if entry.Operation != EntryPut {
+			h.Type = ChangeTypeDelete
+			h.Diff = DiffMetadata(previous, nil)
+			previous = nil
+			history = append(history, h)
+			continue
+		}
+
+		m, err := decodeEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s/%s at revision %d: %w", s.backend.Bucket(), key, entry.Revision, err)
+		}
+		h.Type = ChangeTypePut
+		h.Value = m
+		h.ChangedBy, _ = m.GetAnnotation(constant.ChangedBy)
+		h.Diff = DiffMetadata(previous, m)
+		previous = m
+		history = append(history, h)
+	}
+	return history, nil
+}
+
+// DiffMetadata compares two versions of an object; either may be nil. The
+// changed-by annotation is left out.
+func DiffMetadata(old, new *Metadata) Diff {
+	var oldLabels, oldAnnotations, newLabels, newAnnotations map[string]string
+	var oldConditions, newConditions, oldOwners, newOwners map[string]string
+	if old != nil {
+		oldLabels, oldAnnotations, oldConditions = old.Labels, old.Annotations, conditionValues(old.Status)
+		oldOwners = ownerValues(old.OwnerReferences)
+	}
+	if new != nil {
+		newLabels, newAnnotations, newConditions = new.Labels, new.Annotations, conditionValues(new.Status)
+		newOwners = ownerValues(new.OwnerReferences)
+	}
+	d := Diff{
+		Labels:     diffStringMap(oldLabels, newLabels),
+		Conditions: diffStringMap(oldConditions, newConditions),
+		Owners:     diffStringMap(oldOwners, newOwners),
+	}
+	for _, change := range diffStringMap(oldAnnotations, newAnnotations) {
+		// Who wrote a revision is reported in HistoryEntry.ChangedBy.
+		if change.Key != string(constant.ChangedBy) {
+			d.Annotations = append(d.Annotations, change)
+		}
+	}
+	return d
+}
+
+// conditionValues maps condition types to the values reported in diffs.
+func conditionValues(s Status) map[string]string {
+	values := make(map[string]string, len(s.Conditions))
+	for _, c := range s.Conditions {
+		value := string(c.Status)
+		if c.Reason != "" {
+			value += " (" + c.Reason + ")"
+		}
+		values[c.Type] = value
+	}
+	return values
+}
+
+// ownerValues maps owner references to the empty values reported in diffs.
+func ownerValues(owners []OwnerReference) map[string]string {
+	values := make(map[string]string, len(owners))
+	for _, owner := range owners {
+		values[owner.String()] = ""
+	}
+	return values
+}
+
+func diffStringMap(old, new map[string]string) []FieldChange {
+	var changes []FieldChange
+	for k, ov := range old {
+		nv, ok := new[k]
+		switch {
+		case !ok:
+			changes = append(changes, FieldChange{Key: k, Type: FieldRemoved, OldValue: ov})
+		case nv != ov:
+			changes = append(changes, FieldChange{Key: k, Type: FieldChanged, OldValue: ov, NewValue: nv})
+		}
+	}
+	for k, nv := range new {
+		if _, ok := old[k]; !ok {
+			changes = append(changes, FieldChange{Key: k, Type: FieldAdded, NewValue: nv})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
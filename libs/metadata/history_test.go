@@ -0,0 +1,120 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func newAuditClient(t *testing.T, storage metadata.Storage, changedBy string, history int) *metadata.Client {
+	t.Helper()
+	cfg := metadatatest.NewConfig("")
+	cfg.ChangedBy = changedBy
+	cfg.BucketHistory = history
+	return metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+}
+
+func TestPlayerHistoryRecordsWhoChangedWhat(t *testing.T) {
+	ctx := context.Background()
+	storage := metadata.NewMemoryStorage()
+	shop := newAuditClient(t, storage, "shop", 10)
+	support := newAuditClient(t, storage, "support", 10)
+
+	const uuid = "8f0c2a4e-0000-4000-8000-000000000001"
+	if err := shop.UpdatePlayer(uuid, func(m *metadata.Metadata) { m.SetLabel("tier", "premium") }); err != nil {
+		t.Fatalf("UpdatePlayer failed: %v", err)
+	}
+	if err := support.UpdatePlayerContext(metadata.WithChangedBy(ctx, "support:alice"), uuid, func(m *metadata.Metadata) {
+		m.DeleteLabel("tier")
+		m.SetAnnotation("note", "refund")
+	}); err != nil {
+		t.Fatalf("UpdatePlayer failed: %v", err)
+	}
+	if err := support.DeletePlayer(uuid); err != nil {
+		t.Fatalf("DeletePlayer failed: %v", err)
+	}
+
+	history, err := shop.PlayerHistory(ctx, uuid)
+	if err != nil {
+		t.Fatalf("PlayerHistory failed: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 revisions, got %d", len(history))
+	}
+
+	created := history[0]
+	if created.ChangedBy != "shop" || len(created.Diff.Labels) != 1 ||
+		created.Diff.Labels[0] != (metadata.FieldChange{Key: "tier", Type: metadata.FieldAdded, NewValue: "premium"}) {
+		t.Fatalf("unexpected first revision %+v", created)
+	}
+	if len(created.Diff.Annotations) != 0 {
+		t.Fatalf("changed-by should not show up in the diff: %+v", created.Diff.Annotations)
+	}
+
+	removed := history[1]
+	if removed.ChangedBy != "support:alice" || removed.Revision <= created.Revision {
+		t.Fatalf("unexpected second revision %+v", removed)
+	}
+	if got := removed.Diff.Labels; len(got) != 1 || got[0] != (metadata.FieldChange{Key: "tier", Type: metadata.FieldRemoved, OldValue: "premium"}) {
+		t.Fatalf("expected tier removal, got %+v", got)
+	}
+	if got := removed.Diff.Annotations; len(got) != 1 || got[0].Key != "note" || got[0].Type != metadata.FieldAdded {
+		t.Fatalf("expected note annotation added, got %+v", got)
+	}
+
+	if deleted := history[2]; deleted.Type != metadata.ChangeTypeDelete || deleted.Value != nil || deleted.Diff.Annotations[0].Type != metadata.FieldRemoved {
+		t.Fatalf("unexpected delete revision %+v", deleted)
+	}
+}
+
+func TestHistoryIsBoundedByConfig(t *testing.T) {
+	client := newAuditClient(t, metadata.NewMemoryStorage(), "", 2)
+
+	for _, mode := range []string{"a", "b", "c"} {
+		if err := client.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetLabel("mode", mode) }); err != nil {
+			t.Fatalf("UpdateServer failed: %v", err)
+		}
+	}
+	history, err := client.ServerHistory(context.Background(), "lobby")
+	if err != nil {
+		t.Fatalf("ServerHistory failed: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected the last 2 revisions, got %d", len(history))
+	}
+	// The oldest kept revision is compared to an empty object.
+	if got := history[0].Diff.Labels; len(got) != 1 || got[0].Type != metadata.FieldAdded || got[0].NewValue != "b" {
+		t.Fatalf("unexpected diff of the oldest revision %+v", got)
+	}
+	if got := history[1].Diff.Labels; len(got) != 1 || got[0].Type != metadata.FieldChanged || got[0].OldValue != "b" || got[0].NewValue != "c" {
+		t.Fatalf("unexpected diff of the latest revision %+v", got)
+	}
+	if _, ok := history[1].Value.GetAnnotation(constant.ChangedBy); ok || history[1].ChangedBy != "" {
+		t.Fatalf("a client without ChangedBy should not stamp writes")
+	}
+
+	if _, err := client.ServerHistory(context.Background(), "missing"); !errors.Is(err, metadata.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestServerHistoryOverNATS(t *testing.T) {
+	client := metadatatest.NewClient(t)
+
+	for _, mode := range []string{"lobby", "game"} {
+		if err := client.UpdateServer("survival-1", func(m *metadata.Metadata) { m.SetLabel("mode", mode) }); err != nil {
+			t.Fatalf("UpdateServer failed: %v", err)
+		}
+	}
+	history, err := client.ServerHistory(context.Background(), "survival-1")
+	if err != nil {
+		t.Fatalf("ServerHistory failed: %v", err)
+	}
+	if len(history) != 2 || history[1].Diff.Labels[0].OldValue != "lobby" || history[1].Diff.Labels[0].NewValue != "game" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+}
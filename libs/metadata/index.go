@@ -0,0 +1,128 @@
+package metadata
+
+import (
+	"fmt"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// IndexFunc returns the values under which an object should be indexed.
+// Returning no values leaves the object out of the index.
+type IndexFunc func(key string, m *Metadata) []string
+
+// AnnotationIndexFunc indexes objects by the value of an annotation, e.g.
+// players by their current server.
+func AnnotationIndexFunc(annotation constant.AnnotationKey) IndexFunc {
+	return func(_ string, m *Metadata) []string {
+		if v, ok := m.GetAnnotation(annotation); ok && v != "" {
+			return []string{v}
+		}
+		return nil
+	}
+}
+
+// index maps an indexed value to the set of keys carrying it.
+type index map[string]map[string]struct{}
+
+func (idx index) add(value, key string) {
+	keys, ok := idx[value]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx[value] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (idx index) remove(value, key string) {
+	if keys, ok := idx[value]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(idx, value)
+		}
+	}
+}
+
+// AddIndex registers a custom index, similar to client-go's Indexer. It is
+// built from the current cache and kept in sync by the watcher from then on.
+func (s *Store) AddIndex(name string, fn IndexFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.indexers[name]; exists {
+		return fmt.Errorf("index %q already exists on %s", name, s.kind.Name)
+	}
+	idx := make(index)
+	for key, m := range s.cache {
+		for _, v := range fn(key, m) {
+			idx.add(v, key)
+		}
+	}
+	s.indexers[name] = fn
+	s.indices[name] = idx
+	return nil
+}
+
+// ByIndex returns the objects the named index maps value to.
+func (s *Store) ByIndex(name, value string) (map[string]*Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, exists := s.indices[name]
+	if !exists {
+		return nil, fmt.Errorf("index %q does not exist on %s", name, s.kind.Name)
+	}
+	result := make(map[string]*Metadata, len(idx[value]))
+	for key := range idx[value] {
+		result[key] = s.cache[key].DeepCopy()
+	}
+	return result, nil
+}
+
+// indexLocked adds (or, with remove set, drops) key/m from every index.
+// Callers must hold s.mu.
+func (s *Store) indexLocked(key string, m *Metadata, remove bool) {
+	update := index.add
+	if remove {
+		update = index.remove
+	}
+	for k, v := range m.Labels {
+		idx, ok := s.labelIndex[k]
+		if !ok {
+			if remove {
+				continue
+			}
+			idx = make(index)
+			s.labelIndex[k] = idx
+		}
+		update(idx, v, key)
+		if len(idx) == 0 {
+			delete(s.labelIndex, k)
+		}
+	}
+	for name, fn := range s.indexers {
+		for _, v := range fn(key, m) {
+			update(s.indices[name], v, key)
+		}
+	}
+}
+
+// candidatesLocked narrows the keys that can match selector using the label
+// index. It returns false when no requirement can be answered from the index
+// and a full scan is needed. Callers must hold s.mu.
+func (s *Store) candidatesLocked(selector Selector) ([]string, bool) {
+	var best []string
+	found := false
+	for _, r := range selector {
+		if r.Operator != OpEquals && r.Operator != OpIn {
+			continue
+		}
+		var keys []string
+		for _, v := range r.Values {
+			for key := range s.labelIndex[r.Key][v] {
+				keys = append(keys, key)
+			}
+		}
+		if !found || len(keys) < len(best) {
+			best, found = keys, true
+		}
+	}
+	return best, found
+}
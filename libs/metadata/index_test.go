@@ -0,0 +1,178 @@
+package metadata
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+func newIndexTestStore() *Store {
+	return newStore(&Client{}, ResourceKind{Name: KindPlayers, NameAnnotation: constant.PlayerUsername}, nil)
+}
+
+func putTestObject(s *Store, key string, labels map[string]string, annotations map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(key, &Metadata{Labels: labels, Annotations: annotations})
+}
+
+func deleteTestObject(s *Store, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(key, nil)
+}
+
+// scanByLabel is the linear scan the label index replaced; kept as a reference.
+func scanByLabel(s *Store, key, value string) map[string]*Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string]*Metadata)
+	for k, m := range s.cache {
+		if m.HasLabel(key, value) {
+			result[k] = m
+		}
+	}
+	return result
+}
+
+func TestLabelIndexFollowsUpdates(t *testing.T) {
+	s := newIndexTestStore()
+	putTestObject(s, "a", map[string]string{"tier": "gold", "region": "eu"}, nil)
+	putTestObject(s, "b", map[string]string{"tier": "gold"}, nil)
+	putTestObject(s, "c", map[string]string{"tier": "free"}, nil)
+
+	if got := s.ListByLabel("tier", "gold"); len(got) != 2 {
+		t.Fatalf("expected 2 gold, got %d", len(got))
+	}
+
+	putTestObject(s, "b", map[string]string{"tier": "free"}, nil)
+	if got := s.ListByLabel("tier", "gold"); len(got) != 1 || got["a"] == nil {
+		t.Fatalf("expected only a to be gold after update, got %v", got)
+	}
+
+	deleteTestObject(s, "a")
+	if got := s.ListByLabel("tier", "gold"); len(got) != 0 {
+		t.Fatalf("expected no gold after delete, got %v", got)
+	}
+	if _, ok := s.labelIndex["region"]; ok {
+		t.Fatalf("expected empty label index entries to be dropped")
+	}
+}
+
+func TestSelectUsesIndexAndMatchesScan(t *testing.T) {
+	s := newIndexTestStore()
+	regions := []string{"eu-west", "us-east", "us-west"}
+	for i := 0; i < 300; i++ {
+		labels := map[string]string{"region": regions[i%3]}
+		if i%2 == 0 {
+			labels["tier"] = "free"
+		}
+		if i%7 == 0 {
+			labels["banned"] = "true"
+		}
+		putTestObject(s, fmt.Sprintf("p-%d", i), labels, nil)
+	}
+
+	for _, raw := range []string{
+		"region in (eu-west,us-east),tier!=free,!banned",
+		"region=us-west,banned",
+		"tier notin (free)",
+		"!banned",
+	} {
+		sel, err := ParseSelector(raw)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): %v", raw, err)
+		}
+		got := s.Select(sel)
+		want := 0
+		for _, m := range s.List() {
+			if sel.Matches(m.Labels) {
+				want++
+			}
+		}
+		if len(got) != want {
+			t.Errorf("Select(%q) returned %d objects, want %d", raw, len(got), want)
+		}
+	}
+}
+
+func TestCustomIndex(t *testing.T) {
+	s := newIndexTestStore()
+	currentServer := constant.AnnotationKey("current_server")
+	putTestObject(s, "a", nil, map[string]string{"current_server": "lobby-1"})
+
+	if err := s.AddIndex("server", AnnotationIndexFunc(currentServer)); err != nil {
+		t.Fatalf("AddIndex failed: %v", err)
+	}
+	if err := s.AddIndex("server", AnnotationIndexFunc(currentServer)); err == nil {
+		t.Fatalf("expected duplicate index to fail")
+	}
+
+	putTestObject(s, "b", nil, map[string]string{"current_server": "lobby-1"})
+	putTestObject(s, "c", nil, map[string]string{"current_server": "survival-1"})
+
+	got, err := s.ByIndex("server", "lobby-1")
+	if err != nil || len(got) != 2 {
+		t.Fatalf("expected 2 players on lobby-1, got %v (err=%v)", got, err)
+	}
+
+	putTestObject(s, "a", nil, map[string]string{"current_server": "survival-1"})
+	deleteTestObject(s, "c")
+	got, _ = s.ByIndex("server", "survival-1")
+	if len(got) != 1 || got["a"] == nil {
+		t.Fatalf("expected only a on survival-1, got %v", got)
+	}
+
+	if _, err := s.ByIndex("missing", "x"); err == nil {
+		t.Fatalf("expected error for unknown index")
+	}
+}
+
+func newBenchmarkStore(n int) *Store {
+	s := newIndexTestStore()
+	regions := []string{"eu-west", "us-east", "us-west", "ap-south"}
+	for i := 0; i < n; i++ {
+		putTestObject(s, fmt.Sprintf("player-%d", i), map[string]string{
+			"region": regions[i%len(regions)],
+			"tier":   fmt.Sprintf("tier-%d", i%100),
+		}, nil)
+	}
+	return s
+}
+
+func BenchmarkListByLabel(b *testing.B) {
+	s := newBenchmarkStore(50000)
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.ListByLabel("tier", "tier-42")
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			scanByLabel(s, "tier", "tier-42")
+		}
+	})
+}
+
+func BenchmarkSelect(b *testing.B) {
+	s := newBenchmarkStore(50000)
+	sel, err := ParseSelector("tier in (tier-1,tier-2),region!=us-west")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.Select(sel)
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.mu.RLock()
+			for _, m := range s.cache {
+				sel.Matches(m.Labels)
+			}
+			s.mu.RUnlock()
+		}
+	})
+}
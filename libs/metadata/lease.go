@@ -0,0 +1,103 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultLeaseTTL is used when Config.LeaseTTL is not set.
+const defaultLeaseTTL = 15 * time.Second
+
+// ErrLocksDisabled is returned by the helpers that need a leader lease when
+// Config.LocksBucket is empty.
+var ErrLocksDisabled = errors.New("locks are disabled")
+
+func (c *Client) leaseTTL() time.Duration {
+	if ttl := c.config.LeaseTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultLeaseTTL
+}
+
+// openLocks opens the locks bucket. Leases only need the latest value and
+// expire on the bucket TTL when their holder stops renewing them.
+func (c *Client) openLocks(ctx context.Context) (Backend, error) {
+	bucket := c.config.LocksBucket
+	if bucket == "" {
+		return nil, ErrLocksDisabled
+	}
+	cfg := c.bucketConfig(bucket)
+	cfg.History, cfg.TTL = 1, c.leaseTTL()
+	backend, err := c.storage.Open(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize locks KV: %w", err)
+	}
+	return backend, nil
+}
+
+// defaultLeaseHolder identifies this process in the leases it holds.
+func defaultLeaseHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// lease is a leader lock: the key name in the locks bucket, held by the client
+// that created it until it is deleted or expires. It is not safe for
+// concurrent use.
+type lease struct {
+	backend Backend
+	name    string
+	holder  string
+
+	revision uint64 // revision of our entry while held, 0 otherwise
+}
+
+// held reports whether the last acquire succeeded.
+func (l *lease) held() bool {
+	return l.revision != 0
+}
+
+// acquire takes the lease if it is free, or renews it if already held, and
+// reports whether it is held. Any failure to renew gives the lease up, since
+// it may have expired meanwhile; it is taken again once free.
+func (l *lease) acquire(ctx context.Context) (bool, error) {
+	var (
+		revision uint64
+		err      error
+	)
+	if l.held() {
+		revision, err = l.backend.Update(ctx, l.name, []byte(l.holder), l.revision)
+	} else {
+		revision, err = l.backend.Create(ctx, l.name, []byte(l.holder))
+	}
+	if err != nil {
+		l.revision = 0
+		if errors.Is(err, ErrRevisionMismatch) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to acquire lease %s: %w", l.name, err)
+	}
+	l.revision = revision
+	return true, nil
+}
+
+// release gives the lease up so another client can take it without waiting
+// for it to expire.
+func (l *lease) release(ctx context.Context) error {
+	if !l.held() {
+		return nil
+	}
+	revision := l.revision
+	l.revision = 0
+	err := l.backend.Delete(ctx, l.name, revision)
+	if err != nil && !errors.Is(err, ErrRevisionMismatch) && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to release lease %s: %w", l.name, err)
+	}
+	return nil
+}
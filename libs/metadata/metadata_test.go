@@ -1,36 +1,12 @@
 package metadata
 
 import (
-	"context"
-	"log/slog"
-	"os"
+	"errors"
 	"testing"
-	"time"
-
-	"github.com/nats-io/nats.go"
 
 	"github.com/bafbi/stellaroot/libs/constant"
 )
 
-// startEmbeddedNATSServer attempts to start an in-process NATS server using the nats-server binary
-// present in PATH. If unavailable, tests relying on Client will be skipped.
-func startEmbeddedNATSServer(t *testing.T) (url string, shutdown func()) {
-	// For simplicity we rely on an external nats-server already running on default port.
-	// If it's not reachable we'll skip integration-like tests.
-	url = "nats://127.0.0.1:4222"
-	conn, err := nats.Connect(url, nats.Timeout(500*time.Millisecond))
-	if err != nil {
-		// Not available
-		t.Skipf("skipping client integration tests: can't reach local nats-server: %v", err)
-	}
-	conn.Close()
-	return url, func() {}
-}
-
-func newTestLogger() *slog.Logger {
-	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
-}
-
 func TestMetadataBasicLabelAnnotationOps(t *testing.T) {
 	m := &Metadata{}
 	if m.HasLabel("env", "prod") {
@@ -97,54 +73,41 @@ func TestMetadataStructuredHelpers(t *testing.T) {
 	}
 }
 
-func TestClientPlayerServerFlow(t *testing.T) {
-	url, _ := startEmbeddedNATSServer(t)
-	cfg := &Config{
-		NATSUrl:        url,
-		PlayersBucket:  "players_test",
-		ServersBucket:  "servers_test",
-		ReconnectDelay: 100 * time.Millisecond,
-		MaxReconnects:  1,
-	}
-	client, err := NewClient(context.Background(), cfg, newTestLogger())
-	if err != nil {
-		t.Fatalf("NewClient failed: %v", err)
-	}
-	defer client.Close()
-
-	// Update player and server
-	uuid := "player-123"
-	if err := client.UpdatePlayer(uuid, func(m *Metadata) {
-		m.SetLabel("tier", "gold")
-		m.SetAnnotation(constant.PlayerUsername, "Hero")
-	}); err != nil {
-		t.Fatalf("UpdatePlayer failed: %v", err)
+func TestConflictErrorMatchesErrConflict(t *testing.T) {
+	var err error = &ConflictError{Bucket: "players", Key: "p", Attempts: 3, Err: ErrRevisionMismatch}
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected errors.Is(err, ErrConflict)")
 	}
-
-	// Wait a bit for watcher to process put
-	time.Sleep(200 * time.Millisecond)
-	p, ok := client.GetPlayer(uuid)
-	if !ok || !p.HasLabel("tier", "gold") {
-		t.Fatalf("player not in cache or missing label: ok=%v p=%+v", ok, p)
+	if !errors.Is(err, ErrRevisionMismatch) {
+		t.Fatalf("expected ConflictError to unwrap to the backend error")
 	}
-	if _, ok := client.GetPlayerByName("Hero"); !ok {
-		t.Fatalf("GetPlayerByName failed")
+	var ce *ConflictError
+	if !errors.As(err, &ce) || ce.Attempts != 3 {
+		t.Fatalf("expected errors.As to yield the ConflictError, got %+v", ce)
 	}
+}
 
-	if err := client.UpdateServer("srv-1", func(m *Metadata) { m.SetLabel("region", "eu") }); err != nil {
-		t.Fatalf("UpdateServer failed: %v", err)
-	}
-	time.Sleep(200 * time.Millisecond)
-	if s, ok := client.GetServer("srv-1"); !ok || !s.HasLabel("region", "eu") {
-		t.Fatalf("server missing after update")
+func TestMetadataDeepCopy(t *testing.T) {
+	var nilMeta *Metadata
+	if nilMeta.DeepCopy() != nil {
+		t.Fatalf("expected nil copy of nil metadata")
 	}
 
-	players := client.GetPlayersByLabel("tier", "gold")
-	if len(players) != 1 {
-		t.Fatalf("expected 1 player, got %d", len(players))
-	}
-	servers := client.GetServersByLabel("region", "eu")
-	if len(servers) != 1 {
-		t.Fatalf("expected 1 server, got %d", len(servers))
+	m := &Metadata{Revision: 7}
+	m.SetLabel("a", "1")
+	m.SetAnnotation(constant.AnnotationKey("note"), "x")
+
+	c := m.DeepCopy()
+	c.SetLabel("a", "2")
+	c.SetLabel("b", "3")
+	c.SetAnnotation(constant.AnnotationKey("note"), "y")
+	if !m.HasLabel("a", "1") || len(m.Labels) != 1 || !m.HasAnnotation(constant.AnnotationKey("note"), "x") {
+		t.Fatalf("modifying the copy changed the original: %+v", m)
+	}
+	if c.Revision != 7 {
+		t.Fatalf("expected scalar fields to be copied, got %+v", c)
+	}
+	if (&Metadata{}).DeepCopy().Labels != nil {
+		t.Fatalf("expected nil maps to stay nil")
 	}
 }
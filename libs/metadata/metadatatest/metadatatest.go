@@ -0,0 +1,141 @@
+// Package metadatatest provides helpers for tests that need a real metadata
+// client: an in-process, JetStream-enabled nats-server on a random port with a
+// throwaway store directory, clients wired to it, and clients backed by a
+// metadata.MemoryStorage when no NATS behaviour is needed.
+package metadatatest
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+)
+
+// RunServer starts an embedded nats-server with JetStream enabled. It is shut
+// down and its store directory removed when the test finishes.
+func RunServer(t testing.TB) *server.Server {
+	t.Helper()
+	return RunServerWithOptions(t, DefaultOptions(t))
+}
+
+// DefaultOptions returns the options used by RunServer, to be adjusted before
+// calling RunServerWithOptions.
+func DefaultOptions(t testing.TB) *server.Options {
+	return &server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	}
+}
+
+// RunServerWithOptions starts an embedded nats-server with opts, e.g. with
+// TLS or authentication. It is shut down when the test finishes.
+func RunServerWithOptions(t testing.TB, opts *server.Options) *server.Server {
+	t.Helper()
+
+	s, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("metadatatest: failed to create nats-server: %v", err)
+	}
+
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		t.Fatalf("metadatatest: nats-server did not become ready")
+	}
+
+	t.Cleanup(func() {
+		s.Shutdown()
+		s.WaitForShutdown()
+	})
+	return s
+}
+
+// NewConfig returns a client configuration pointing at url with short
+// reconnect settings suited to tests.
+func NewConfig(url string) *metadata.Config {
+	return &metadata.Config{
+		NATSUrl:          url,
+		PlayersBucket:    "players",
+		ServersBucket:    "servers",
+		BucketHistory:    10,
+		HeartbeatsBucket: "heartbeats",
+		LocksBucket:      "locks",
+		ReconcileBuckets: true,
+		ReconnectDelay:   100 * time.Millisecond,
+		MaxReconnects:    1,
+	}
+}
+
+// NewClient starts a fresh embedded server and returns a client connected to
+// it, with its caches synced. Both are torn down when the test finishes.
+func NewClient(t testing.TB) *metadata.Client {
+	t.Helper()
+	return NewClientForServer(t, RunServer(t))
+}
+
+// NewClientForServer returns an additional client connected to s, e.g. to
+// simulate two services sharing the same buckets.
+func NewClientForServer(t testing.TB, s *server.Server) *metadata.Client {
+	t.Helper()
+	return NewClientWithConfig(t, NewConfig(s.ClientURL()))
+}
+
+// NewClientWithConfig returns a client built from cfg, closed when the test finishes.
+func NewClientWithConfig(t testing.TB, cfg *metadata.Config) *metadata.Client {
+	t.Helper()
+	client, err := metadata.NewClient(context.Background(), cfg, newLogger())
+	if err != nil {
+		t.Fatalf("metadatatest: NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	waitForSync(t, client)
+	return client
+}
+
+// NewMemoryClient returns a client backed by a fresh metadata.MemoryStorage,
+// closed when the test finishes.
+func NewMemoryClient(t testing.TB) *metadata.Client {
+	t.Helper()
+	return NewMemoryClientForStorage(t, metadata.NewMemoryStorage())
+}
+
+// NewMemoryClientForStorage returns an additional client sharing storage.
+func NewMemoryClientForStorage(t testing.TB, storage metadata.Storage) *metadata.Client {
+	t.Helper()
+	return NewMemoryClientWithConfig(t, storage, NewConfig(""))
+}
+
+// NewMemoryClientWithConfig returns a client on storage built from cfg, e.g.
+// with a short HeartbeatTTL.
+func NewMemoryClientWithConfig(t testing.TB, storage metadata.Storage, cfg *metadata.Config) *metadata.Client {
+	t.Helper()
+	client, err := metadata.NewClientWithStorage(context.Background(), cfg, storage, newLogger())
+	if err != nil {
+		t.Fatalf("metadatatest: NewClientWithStorage failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	waitForSync(t, client)
+	return client
+}
+
+func waitForSync(t testing.TB, client *metadata.Client) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.WaitForSync(ctx); err != nil {
+		t.Fatalf("metadatatest: WaitForSync failed: %v", err)
+	}
+}
+
+func newLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+}
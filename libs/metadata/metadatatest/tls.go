@@ -0,0 +1,123 @@
+package metadatatest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// TLSFiles are the PEM files written by RunTLSServer: a self-signed CA, and
+// a client certificate and key signed by it.
+type TLSFiles struct {
+	CA         string
+	ClientCert string
+	ClientKey  string
+}
+
+// RunTLSServer starts an embedded nats-server that only accepts TLS clients
+// presenting a certificate signed by a throwaway CA. Use the returned files in
+// the client config.
+func RunTLSServer(t testing.TB) (*server.Server, TLSFiles) {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, caCert := newCert(t, "metadatatest CA", nil, nil, func(tmpl *x509.Certificate) {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	})
+	serverKey, serverCert := newCert(t, "127.0.0.1", caKey, caCert, func(tmpl *x509.Certificate) {
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	})
+	clientKey, clientCert := newCert(t, "metadatatest client", caKey, caCert, func(tmpl *x509.Certificate) {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	})
+
+	files := TLSFiles{
+		CA:         writePEM(t, dir, "ca.pem", "CERTIFICATE", caCert.Raw),
+		ClientCert: writePEM(t, dir, "client.pem", "CERTIFICATE", clientCert.Raw),
+		ClientKey:  writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", marshalKey(t, clientKey)),
+	}
+	serverCertFile := writePEM(t, dir, "server.pem", "CERTIFICATE", serverCert.Raw)
+	serverKeyFile := writePEM(t, dir, "server-key.pem", "EC PRIVATE KEY", marshalKey(t, serverKey))
+
+	tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+		CertFile: serverCertFile,
+		KeyFile:  serverKeyFile,
+		CaFile:   files.CA,
+		Verify:   true,
+	})
+	if err != nil {
+		t.Fatalf("metadatatest: failed to build server TLS config: %v", err)
+	}
+
+	opts := DefaultOptions(t)
+	opts.TLS = true
+	opts.TLSVerify = true
+	opts.TLSConfig = tlsConfig
+	return RunServerWithOptions(t, opts), files
+}
+
+// newCert creates a key and a certificate for it, signed by parentKey or, when
+// nil, self-signed.
+func newCert(t testing.TB, cn string, parentKey *ecdsa.PrivateKey, parent *x509.Certificate, customize func(*x509.Certificate)) (*ecdsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("metadatatest: failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("metadatatest: failed to generate serial: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	customize(tmpl)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("metadatatest: failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("metadatatest: failed to parse certificate: %v", err)
+	}
+	return key, cert
+}
+
+func marshalKey(t testing.TB, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("metadatatest: failed to marshal key: %v", err)
+	}
+	return der
+}
+
+func writePEM(t testing.TB, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("metadatatest: failed to write %s: %v", name, err)
+	}
+	return path
+}
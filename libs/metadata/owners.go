@@ -0,0 +1,111 @@
+package metadata
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// OwnerReference points at the object that owns another one, by kind name
+// (e.g. KindServers) and key.
+type OwnerReference struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+}
+
+func (r OwnerReference) String() string {
+	return r.Kind + "/" + r.Key
+}
+
+// SetOwner adds an owner reference to m, unless it is already there.
+func (m *Metadata) SetOwner(kind, key string) {
+	if !m.IsOwnedBy(kind, key) {
+		m.OwnerReferences = append(m.OwnerReferences, OwnerReference{Kind: kind, Key: key})
+	}
+}
+
+// RemoveOwner removes an owner reference from m, reporting whether it was
+// present.
+func (m *Metadata) RemoveOwner(kind, key string) bool {
+	n := len(m.OwnerReferences)
+	m.OwnerReferences = slices.DeleteFunc(m.OwnerReferences, func(r OwnerReference) bool {
+		return r.Kind == kind && r.Key == key
+	})
+	return len(m.OwnerReferences) != n
+}
+
+// IsOwnedBy reports whether m has an owner reference to kind/key.
+func (m *Metadata) IsOwnedBy(kind, key string) bool {
+	return slices.Contains(m.OwnerReferences, OwnerReference{Kind: kind, Key: key})
+}
+
+// PropagationPolicy is what happens to the dependents of a deleted object,
+// the objects with an owner reference to it.
+type PropagationPolicy int
+
+const (
+	// DeleteBackground deletes the object right away; the garbage collector
+	// then deletes its dependents. It is what a plain delete does.
+	DeleteBackground PropagationPolicy = iota
+	// DeleteForeground marks the object for deletion and leaves it to the
+	// garbage collector, which deletes the dependents first, their own
+	// dependents before them, and the object once none are left.
+	DeleteForeground
+	// DeleteOrphan marks the object for deletion and leaves it to the
+	// garbage collector, which removes the owner reference from the
+	// dependents, then deletes the object. The dependents are kept.
+	DeleteOrphan
+)
+
+func (p PropagationPolicy) String() string {
+	switch p {
+	case DeleteBackground:
+		return "background"
+	case DeleteForeground:
+		return "foreground"
+	case DeleteOrphan:
+		return "orphan"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePropagationPolicy parses "background", "foreground" or "orphan".
+func ParsePropagationPolicy(s string) (PropagationPolicy, error) {
+	switch s {
+	case "background":
+		return DeleteBackground, nil
+	case "foreground":
+		return DeleteForeground, nil
+	case "orphan":
+		return DeleteOrphan, nil
+	default:
+		return 0, fmt.Errorf("invalid propagation policy %q, want background, foreground or orphan", s)
+	}
+}
+
+// MarshalText encodes the policy as in ParsePropagationPolicy.
+func (p PropagationPolicy) MarshalText() ([]byte, error) {
+	if p < DeleteBackground || p > DeleteOrphan {
+		return nil, fmt.Errorf("unknown propagation policy %d", p)
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a policy written by MarshalText.
+func (p *PropagationPolicy) UnmarshalText(text []byte) error {
+	policy, err := ParsePropagationPolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// Deletion records a deletion in progress (see Metadata.Deletion).
+type Deletion struct {
+	Policy PropagationPolicy `json:"policy"`
+	// Purge drops the history of the object once it is deleted.
+	Purge       bool      `json:"purge,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
@@ -0,0 +1,394 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"strings"
+)
+
+// ErrInvalidPatch is matched by errors for patches that are malformed or do
+// not fit the metadata document, e.g. a label set to a number.
+var ErrInvalidPatch = errors.New("metadata: invalid patch")
+
+// ErrPatchFailed is matched by errors for JSON Patch documents that do not
+// apply to the current object: a "test" operation did not hold, or an
+// operation targets an absent key. Nothing is written.
+var ErrPatchFailed = errors.New("metadata: patch does not apply")
+
+// PatchType selects how a patch document is interpreted.
+type PatchType int
+
+const (
+	// MergePatch is an RFC 7386 JSON Merge Patch: objects are merged and
+	// null removes a key, e.g. {"labels":{"tier":"premium","trial":null}}.
+	MergePatch PatchType = iota
+	// JSONPatch is an RFC 6902 JSON Patch: a list of add, remove, replace,
+	// move, copy and test operations, e.g.
+	// [{"op":"test","path":"/labels/tier","value":"free"},
+	//  {"op":"replace","path":"/labels/tier","value":"premium"}].
+	JSONPatch
+)
+
+// Content types of the patch formats, as used in HTTP PATCH requests.
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+func (t PatchType) String() string {
+	switch t {
+	case MergePatch:
+		return "merge"
+	case JSONPatch:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the type as "merge" or "json", as in admission responses.
+func (t PatchType) MarshalText() ([]byte, error) {
+	if t != MergePatch && t != JSONPatch {
+		return nil, fmt.Errorf("unknown patch type %d", t)
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes "merge" or "json".
+func (t *PatchType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "merge":
+		*t = MergePatch
+	case "json":
+		*t = JSONPatch
+	default:
+		return fmt.Errorf("unknown patch type %q, want merge or json", text)
+	}
+	return nil
+}
+
+// ContentType returns the media type of the patch format.
+func (t PatchType) ContentType() string {
+	if t == JSONPatch {
+		return JSONPatchContentType
+	}
+	return MergePatchContentType
+}
+
+// PatchTypeForContentType maps a Content-Type header to a PatchType.
+func PatchTypeForContentType(contentType string) (PatchType, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0, false
+	}
+	switch mediaType {
+	case MergePatchContentType:
+		return MergePatch, true
+	case JSONPatchContentType:
+		return JSONPatch, true
+	default:
+		return 0, false
+	}
+}
+
+// PatchOption configures Patch.
+type PatchOption func(*patchOptions)
+
+type patchOptions struct {
+	revision uint64
+}
+
+// WithPatchRevision makes the patch conditional: it only applies if revision
+// is still the latest revision of the key, otherwise a *ConflictError is
+// returned. Without it, a patch that loses a race is re-applied to the latest
+// revision, with its test operations evaluated again.
+func WithPatchRevision(revision uint64) PatchOption {
+	return func(o *patchOptions) { o.revision = revision }
+}
+
+// PatchPlayer applies a patch to a player. See Store.Patch.
+func (c *Client) PatchPlayer(ctx context.Context, uuid string, patchType PatchType, patch []byte, opts ...PatchOption) (*Metadata, error) {
+	return c.players.Patch(ctx, uuid, patchType, patch, opts...)
+}
+
+// PatchServer applies a patch to a server. See Store.Patch.
+func (c *Client) PatchServer(ctx context.Context, name string, patchType PatchType, patch []byte, opts ...PatchOption) (*Metadata, error) {
+	return c.servers.Patch(ctx, name, patchType, patch, opts...)
+}
+
+// Patch applies a patch document to the labels and annotations of key as a
+// single conditional write; an absent key is patched as an empty object and
+// created. The document is {"labels":{...},"annotations":{...}}, so unlike
+// Update an empty string is a value like any other. If the patch is invalid or
+// a test fails, nothing is written. It returns the written object, whose
+// UpdatedAt is the local time of the write.
+func (s *Store) Patch(ctx context.Context, key string, patchType PatchType, patch []byte, opts ...PatchOption) (*Metadata, error) {
+	var o patchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// Decode once so a malformed document fails before any KV round-trip.
+	apply, err := compilePatch(patchType, patch)
+	if err != nil {
+		return nil, err
+	}
+	return s.modify(ctx, key, o.revision, apply)
+}
+
+// ApplyPatch applies a patch document to m in place. On error m may be
+// partially patched.
+func ApplyPatch(m *Metadata, patchType PatchType, patch []byte) error {
+	apply, err := compilePatch(patchType, patch)
+	if err != nil {
+		return err
+	}
+	if m.Labels == nil {
+		m.Labels = make(map[string]string)
+	}
+	if m.Annotations == nil {
+		m.Annotations = make(map[string]string)
+	}
+	return apply(m)
+}
+
+func compilePatch(patchType PatchType, patch []byte) (func(*Metadata) error, error) {
+	switch patchType {
+	case MergePatch:
+		return compileMergePatch(patch)
+	case JSONPatch:
+		return compileJSONPatch(patch)
+	default:
+		return nil, fmt.Errorf("%w: unknown patch type %d", ErrInvalidPatch, patchType)
+	}
+}
+
+// mergeField is the decoded merge patch of labels or annotations.
+type mergeField struct {
+	clear  bool               // the field was null
+	values map[string]*string // a nil value removes the key
+}
+
+func compileMergePatch(patch []byte) (func(*Metadata) error, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &doc); err != nil || doc == nil {
+		return nil, fmt.Errorf("%w: a merge patch must be a JSON object", ErrInvalidPatch)
+	}
+	fields := make(map[string]mergeField, len(doc))
+	for field, raw := range doc {
+		if field != "labels" && field != "annotations" {
+			return nil, fmt.Errorf("%w: field %q cannot be patched", ErrInvalidPatch, field)
+		}
+		if isNull(raw) {
+			fields[field] = mergeField{clear: true}
+			continue
+		}
+		var values map[string]*string
+		if err := json.Unmarshal(raw, &values); err != nil || values == nil {
+			return nil, fmt.Errorf("%w: %s must be an object of strings or null", ErrInvalidPatch, field)
+		}
+		fields[field] = mergeField{values: values}
+	}
+
+	return func(m *Metadata) error {
+		for field, f := range fields {
+			target := m.Labels
+			if field == "annotations" {
+				target = m.Annotations
+			}
+			if f.clear {
+				clear(target)
+				continue
+			}
+			for k, v := range f.values {
+				if v == nil {
+					delete(target, k)
+				} else {
+					target[k] = *v
+				}
+			}
+		}
+		return nil
+	}, nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// jsonPatchOp is one RFC 6902 operation.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// pointer is a JSON Pointer into the metadata document: a field ("labels" or
+// "annotations") and, unless it points at the whole field, a key.
+type pointer struct {
+	field string
+	key   string
+	whole bool
+}
+
+func (p pointer) String() string {
+	if p.whole {
+		return "/" + p.field
+	}
+	return "/" + p.field + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(p.key)
+}
+
+func parsePointer(path string) (pointer, error) {
+	rest, ok := strings.CutPrefix(path, "/")
+	if !ok {
+		return pointer{}, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, path)
+	}
+	field, key, hasKey := strings.Cut(rest, "/")
+	if field != "labels" && field != "annotations" {
+		return pointer{}, fmt.Errorf("%w: path %q must be under /labels or /annotations", ErrInvalidPatch, path)
+	}
+	if !hasKey {
+		return pointer{field: field, whole: true}, nil
+	}
+	if strings.Contains(key, "/") {
+		return pointer{}, fmt.Errorf("%w: path %q is too deep, escape / in keys as ~1", ErrInvalidPatch, path)
+	}
+	// RFC 6901: ~1 is /, then ~0 is ~.
+	key = strings.ReplaceAll(strings.ReplaceAll(key, "~1", "/"), "~0", "~")
+	return pointer{field: field, key: key}, nil
+}
+
+// compiledOp is a validated operation with its value decoded.
+type compiledOp struct {
+	index  int
+	op     string
+	path   pointer
+	from   pointer
+	value  string            // for key paths
+	values map[string]string // for whole-field paths
+}
+
+func compileJSONPatch(patch []byte) (func(*Metadata) error, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: a JSON patch must be an array of operations", ErrInvalidPatch)
+	}
+	compiled := make([]compiledOp, 0, len(ops))
+	for i, op := range ops {
+		c := compiledOp{index: i, op: op.Op}
+		invalid := func(format string, args ...any) error {
+			return fmt.Errorf("%w: operation %d (%s %s): %s", ErrInvalidPatch, i, op.Op, op.Path, fmt.Sprintf(format, args...))
+		}
+		var err error
+		if c.path, err = parsePointer(op.Path); err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil || isNull(op.Value) {
+				return nil, invalid("missing value")
+			}
+			if c.path.whole {
+				if err := json.Unmarshal(op.Value, &c.values); err != nil || c.values == nil {
+					return nil, invalid("value must be an object of strings")
+				}
+			} else if err := json.Unmarshal(op.Value, &c.value); err != nil {
+				return nil, invalid("value must be a string")
+			}
+		case "remove":
+		case "move", "copy":
+			if c.from, err = parsePointer(op.From); err != nil {
+				return nil, err
+			}
+			if c.from.whole != c.path.whole {
+				return nil, invalid("from and path must both be fields or both be keys")
+			}
+		default:
+			return nil, invalid("unknown operation")
+		}
+		compiled = append(compiled, c)
+	}
+
+	return func(m *Metadata) error {
+		for _, op := range compiled {
+			if err := op.apply(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+func (op compiledOp) apply(m *Metadata) error {
+	fieldOf := func(p pointer) map[string]string {
+		if p.field == "annotations" {
+			return m.Annotations
+		}
+		return m.Labels
+	}
+	failed := func(sentinel error, format string, args ...any) error {
+		return fmt.Errorf("%w: operation %d (%s %s): %s", sentinel, op.index, op.op, op.path, fmt.Sprintf(format, args...))
+	}
+	target := fieldOf(op.path)
+
+	if op.path.whole {
+		switch op.op {
+		case "test":
+			if !maps.Equal(target, op.values) {
+				return failed(ErrPatchFailed, "%s differ", op.path.field)
+			}
+		case "add", "replace":
+			clear(target)
+			maps.Copy(target, op.values)
+		case "remove":
+			clear(target)
+		case "move", "copy":
+			values := maps.Clone(fieldOf(op.from))
+			if op.op == "move" && op.from.field != op.path.field {
+				clear(fieldOf(op.from))
+			}
+			clear(target)
+			maps.Copy(target, values)
+		}
+		return nil
+	}
+
+	current, exists := target[op.path.key]
+	switch op.op {
+	case "test":
+		if !exists {
+			return failed(ErrPatchFailed, "key is absent")
+		}
+		if current != op.value {
+			return failed(ErrPatchFailed, "value is %q, want %q", current, op.value)
+		}
+	case "add":
+		target[op.path.key] = op.value
+	case "replace":
+		if !exists {
+			return failed(ErrPatchFailed, "key is absent")
+		}
+		target[op.path.key] = op.value
+	case "remove":
+		if !exists {
+			return failed(ErrPatchFailed, "key is absent")
+		}
+		delete(target, op.path.key)
+	case "move", "copy":
+		source := fieldOf(op.from)
+		value, ok := source[op.from.key]
+		if !ok {
+			return failed(ErrPatchFailed, "from %s is absent", op.from)
+		}
+		if op.op == "move" {
+			delete(source, op.from.key)
+		}
+		target[op.path.key] = value
+	}
+	return nil
+}
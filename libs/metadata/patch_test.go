@@ -0,0 +1,142 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func TestMergePatch(t *testing.T) {
+	ctx := context.Background()
+	client := metadatatest.NewMemoryClient(t)
+	if err := client.UpdateServer("lobby", func(m *metadata.Metadata) {
+		m.SetLabel("mode", "lobby")
+		m.SetLabel("trial", "true")
+		m.SetAnnotation("motd", "hello")
+	}); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+
+	m, err := client.PatchServer(ctx, "lobby", metadata.MergePatch,
+		[]byte(`{"labels":{"region":"eu","trial":null},"annotations":{"motd":""}}`))
+	if err != nil {
+		t.Fatalf("PatchServer failed: %v", err)
+	}
+	if want := map[string]string{"mode": "lobby", "region": "eu"}; !maps.Equal(m.Labels, want) {
+		t.Fatalf("expected labels %v, got %v", want, m.Labels)
+	}
+	if motd, ok := m.Annotations["motd"]; !ok || motd != "" {
+		t.Fatalf("expected an empty motd to be stored, got %q (present %v)", motd, ok)
+	}
+
+	m, err = client.PatchServer(ctx, "lobby", metadata.MergePatch, []byte(`{"labels":null}`))
+	if err != nil {
+		t.Fatalf("PatchServer failed: %v", err)
+	}
+	if len(m.Labels) != 0 || len(m.Annotations) == 0 {
+		t.Fatalf("null should clear labels only, got %+v", m)
+	}
+
+	for _, patch := range []string{`[]`, `{"created_at":"2020-01-01T00:00:00Z"}`, `{"labels":{"tier":5}}`, `{"labels":"x"}`} {
+		if _, err := client.PatchServer(ctx, "lobby", metadata.MergePatch, []byte(patch)); !errors.Is(err, metadata.ErrInvalidPatch) {
+			t.Fatalf("patch %s: expected ErrInvalidPatch, got %v", patch, err)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	ctx := context.Background()
+	client := metadatatest.NewMemoryClient(t)
+	const uuid = "8f0c2a4e-0000-4000-8000-000000000001"
+	if err := client.UpdatePlayer(uuid, func(m *metadata.Metadata) {
+		m.SetLabel("tier", "free")
+		m.SetAnnotation("player/username", "Notch")
+	}); err != nil {
+		t.Fatalf("UpdatePlayer failed: %v", err)
+	}
+
+	m, err := client.PatchPlayer(ctx, uuid, metadata.JSONPatch, []byte(`[
+		{"op":"test","path":"/labels/tier","value":"free"},
+		{"op":"replace","path":"/labels/tier","value":"premium"},
+		{"op":"copy","from":"/annotations/player~1username","path":"/labels/name"},
+		{"op":"add","path":"/annotations/note","value":""}
+	]`))
+	if err != nil {
+		t.Fatalf("PatchPlayer failed: %v", err)
+	}
+	if m.Labels["tier"] != "premium" || m.Labels["name"] != "Notch" {
+		t.Fatalf("unexpected labels %v", m.Labels)
+	}
+	if note, ok := m.Annotations["note"]; !ok || note != "" {
+		t.Fatalf("expected an empty note annotation, got %q (present %v)", note, ok)
+	}
+
+	// A failed test aborts the whole patch.
+	_, err = client.PatchPlayer(ctx, uuid, metadata.JSONPatch, []byte(`[
+		{"op":"remove","path":"/labels/name"},
+		{"op":"test","path":"/labels/tier","value":"free"}
+	]`))
+	if !errors.Is(err, metadata.ErrPatchFailed) {
+		t.Fatalf("expected ErrPatchFailed, got %v", err)
+	}
+	history, err := client.PlayerHistory(ctx, uuid)
+	if err != nil {
+		t.Fatalf("PlayerHistory failed: %v", err)
+	}
+	if last := history[len(history)-1]; last.Revision != m.Revision {
+		t.Fatalf("a failed patch must not write, latest revision %d, patched %d", last.Revision, m.Revision)
+	}
+
+	if _, err := client.PatchPlayer(ctx, uuid, metadata.JSONPatch, []byte(`[{"op":"remove","path":"/labels/missing"}]`)); !errors.Is(err, metadata.ErrPatchFailed) {
+		t.Fatalf("expected ErrPatchFailed removing an absent key, got %v", err)
+	}
+	for _, patch := range []string{
+		`{}`,
+		`[{"op":"add","path":"/created_at","value":"x"}]`,
+		`[{"op":"add","path":"/labels/a/b","value":"x"}]`,
+		`[{"op":"add","path":"/labels/tier","value":1}]`,
+		`[{"op":"add","path":"/labels/tier"}]`,
+		`[{"op":"frobnicate","path":"/labels/tier"}]`,
+	} {
+		if _, err := client.PatchPlayer(ctx, uuid, metadata.JSONPatch, []byte(patch)); !errors.Is(err, metadata.ErrInvalidPatch) {
+			t.Fatalf("patch %s: expected ErrInvalidPatch, got %v", patch, err)
+		}
+	}
+}
+
+func TestPatchWithRevision(t *testing.T) {
+	ctx := context.Background()
+	client := metadatatest.NewMemoryClient(t)
+
+	m, err := client.PatchServer(ctx, "lobby", metadata.MergePatch, []byte(`{"labels":{"mode":"lobby"}}`))
+	if err != nil {
+		t.Fatalf("PatchServer failed: %v", err)
+	}
+	stale := m.Revision
+	if _, err := client.PatchServer(ctx, "lobby", metadata.MergePatch, []byte(`{"labels":{"mode":"game"}}`), metadata.WithPatchRevision(stale)); err != nil {
+		t.Fatalf("PatchServer with the latest revision failed: %v", err)
+	}
+	_, err = client.PatchServer(ctx, "lobby", metadata.MergePatch, []byte(`{"labels":{"mode":"hub"}}`), metadata.WithPatchRevision(stale))
+	var conflict *metadata.ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, metadata.ErrConflict) {
+		t.Fatalf("expected a conflict with a stale revision, got %v", err)
+	}
+}
+
+func TestPatchTypeForContentType(t *testing.T) {
+	for contentType, want := range map[string]metadata.PatchType{
+		"application/merge-patch+json":               metadata.MergePatch,
+		"application/json-patch+json; charset=utf-8": metadata.JSONPatch,
+	} {
+		if got, ok := metadata.PatchTypeForContentType(contentType); !ok || got != want {
+			t.Fatalf("%s: expected %v, got %v (ok %v)", contentType, want, got, ok)
+		}
+	}
+	if _, ok := metadata.PatchTypeForContentType("application/json"); ok {
+		t.Fatalf("plain JSON is not a patch format")
+	}
+}
@@ -1,66 +1,55 @@
 package metadata
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 )
 
 func (c *Client) GetPlayer(uuid string) (*Metadata, bool) {
-	c.playersMu.RLock()
-	defer c.playersMu.RUnlock()
-	player, exists := c.playersCache[uuid]
-	return player, exists
+	return c.players.Get(uuid)
 }
 
 func (c *Client) GetPlayerByName(name string) (*Metadata, bool) {
-	c.playersMu.RLock()
-	defer c.playersMu.RUnlock()
-
-	if uuid, exists := c.playersNameToUUID[name]; exists {
-		if player, playerExists := c.playersCache[uuid]; playerExists {
-			return player, true
-		}
-	}
-	return nil, false
+	return c.players.GetByName(name)
 }
 
+// UpdatePlayer applies updateFunc to the latest stored value of the player and
+// writes it back only if nobody else wrote in between. On a conflict the write is
+// retried with fresh state; a *ConflictError is returned once retries run out.
 func (c *Client) UpdatePlayer(uuid string, updateFunc func(*Metadata)) error {
-	c.playersMu.Lock()
-	defer c.playersMu.Unlock()
-
-	player, exists := c.playersCache[uuid]
-	if !exists {
-		player = &Metadata{}
-	}
-
-	playerCopy := &Metadata{Labels: make(map[string]string), Annotations: make(map[string]string)}
-	if player.Labels != nil {
-		for k, v := range player.Labels {
-			playerCopy.Labels[k] = v
-		}
-	}
-	if player.Annotations != nil {
-		for k, v := range player.Annotations {
-			playerCopy.Annotations[k] = v
-		}
-	}
+	return c.players.Update(uuid, updateFunc)
+}
 
-	updateFunc(playerCopy)
+// UpdatePlayerContext is UpdatePlayer bounded by ctx.
+func (c *Client) UpdatePlayerContext(ctx context.Context, uuid string, updateFunc func(*Metadata)) error {
+	return c.players.UpdateContext(ctx, uuid, updateFunc)
+}
 
-	data, err := json.Marshal(playerCopy)
-	if err != nil {
-		return err
-	}
-	_, err = c.playersKV.Put(uuid, data)
-	return err
+// UpdatePlayerAndWait is UpdatePlayer that returns once the local cache holds
+// the write, together with the resulting metadata. See Store.UpdateAndWait.
+func (c *Client) UpdatePlayerAndWait(ctx context.Context, uuid string, updateFunc func(*Metadata)) (*Metadata, error) {
+	return c.players.UpdateAndWait(ctx, uuid, updateFunc)
 }
 
 func (c *Client) UpdatePlayerByName(name string, updateFunc func(*Metadata)) error {
-	c.playersMu.RLock()
-	uuid, exists := c.playersNameToUUID[name]
-	c.playersMu.RUnlock()
+	return c.UpdatePlayerByNameContext(context.Background(), name, updateFunc)
+}
+
+// UpdatePlayerByNameContext is UpdatePlayerByName bounded by ctx.
+func (c *Client) UpdatePlayerByNameContext(ctx context.Context, name string, updateFunc func(*Metadata)) error {
+	uuid, exists := c.players.KeyForName(name)
 	if !exists {
 		return fmt.Errorf("player with name '%s' not found", name)
 	}
-	return c.UpdatePlayer(uuid, updateFunc)
+	return c.UpdatePlayerContext(ctx, uuid, updateFunc)
+}
+
+// DeletePlayer removes a player. ErrNotFound is returned if it does not exist.
+func (c *Client) DeletePlayer(uuid string, opts ...DeleteOption) error {
+	return c.players.Delete(uuid, opts...)
+}
+
+// DeletePlayerContext is DeletePlayer bounded by ctx.
+func (c *Client) DeletePlayerContext(ctx context.Context, uuid string, opts ...DeleteOption) error {
+	return c.players.DeleteContext(ctx, uuid, opts...)
 }
@@ -1,48 +1,66 @@
 package metadata
 
 func (c *Client) GetPlayersByLabel(key, value string) map[string]*Metadata {
-	c.playersMu.RLock()
-	defer c.playersMu.RUnlock()
-	result := make(map[string]*Metadata)
-	for uuid, player := range c.playersCache {
-		if player.HasLabel(key, value) {
-			result[uuid] = player
-		}
-	}
-	return result
+	return c.players.ListByLabel(key, value)
 }
 
 func (c *Client) GetPlayersByLabels(labels map[string]string) map[string]*Metadata {
-	c.playersMu.RLock()
-	defer c.playersMu.RUnlock()
-	result := make(map[string]*Metadata)
-	for uuid, player := range c.playersCache {
-		if player.HasLabels(labels) {
-			result[uuid] = player
-		}
-	}
-	return result
+	return c.players.ListByLabels(labels)
+}
+
+// SelectPlayers returns the players matching a label selector.
+func (c *Client) SelectPlayers(selector Selector) map[string]*Metadata {
+	return c.players.Select(selector)
 }
 
 func (c *Client) GetServersByLabel(key, value string) map[string]*Metadata {
-	c.serversMu.RLock()
-	defer c.serversMu.RUnlock()
-	result := make(map[string]*Metadata)
-	for name, server := range c.serversCache {
-		if server.HasLabel(key, value) {
-			result[name] = server
-		}
+	return c.servers.ListByLabel(key, value)
+}
+
+func (c *Client) GetServersByLabels(labels map[string]string) map[string]*Metadata {
+	return c.servers.ListByLabels(labels)
+}
+
+// SelectServers returns the servers matching a label selector.
+func (c *Client) SelectServers(selector Selector) map[string]*Metadata {
+	return c.servers.Select(selector)
+}
+
+// ListByLabel returns the objects whose label key equals value.
+func (s *Store) ListByLabel(key, value string) map[string]*Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.labelIndex[key][value]
+	result := make(map[string]*Metadata, len(keys))
+	for k := range keys {
+		result[k] = s.cache[k].DeepCopy()
 	}
 	return result
 }
 
-func (c *Client) GetServersByLabels(labels map[string]string) map[string]*Metadata {
-	c.serversMu.RLock()
-	defer c.serversMu.RUnlock()
+// ListByLabels returns the objects carrying all of the given labels.
+func (s *Store) ListByLabels(labels map[string]string) map[string]*Metadata {
+	return s.Select(SelectorFromLabels(labels))
+}
+
+// Select returns the objects whose labels match selector. Equality and set
+// requirements are answered from the label index; the rest of the selector is
+// checked against the narrowed candidates.
+func (s *Store) Select(selector Selector) map[string]*Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	result := make(map[string]*Metadata)
-	for name, server := range c.serversCache {
-		if server.HasLabels(labels) {
-			result[name] = server
+	if keys, ok := s.candidatesLocked(selector); ok {
+		for _, k := range keys {
+			if m := s.cache[k]; selector.Matches(m.Labels) {
+				result[k] = m.DeepCopy()
+			}
+		}
+		return result
+	}
+	for k, m := range s.cache {
+		if selector.Matches(m.Labels) {
+			result[k] = m.DeepCopy()
 		}
 	}
 	return result
@@ -0,0 +1,184 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// ValidationMode decides what the client does with annotation values that
+// fail their descriptor (see Config.AnnotationValidation).
+type ValidationMode int
+
+const (
+	// ValidationOff writes annotations unchecked.
+	ValidationOff ValidationMode = iota
+	// ValidationWarn logs invalid annotations and writes them anyway.
+	ValidationWarn
+	// ValidationReject fails the write with a *ValidationError.
+	ValidationReject
+)
+
+func (m ValidationMode) String() string {
+	switch m {
+	case ValidationOff:
+		return "off"
+	case ValidationWarn:
+		return "warn"
+	case ValidationReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseValidationMode parses "off", "warn" or "reject".
+func ParseValidationMode(s string) (ValidationMode, error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return ValidationOff, nil
+	case "warn":
+		return ValidationWarn, nil
+	case "reject":
+		return ValidationReject, nil
+	default:
+		return 0, fmt.Errorf("invalid validation mode %q, want off, warn or reject", s)
+	}
+}
+
+// ErrValidation is matched (via errors.Is) by every ValidationError.
+var ErrValidation = errors.New("metadata: invalid annotations")
+
+// ErrUnknownAnnotation is the FieldError cause for annotations without a
+// registered descriptor when Config.StrictAnnotations is set.
+var ErrUnknownAnnotation = errors.New("unknown annotation")
+
+// FieldError is one annotation that failed validation.
+type FieldError struct {
+	Key   constant.AnnotationKey
+	Value string
+	Err   error // the descriptor's parse error, or ErrUnknownAnnotation
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e FieldError) Unwrap() error { return e.Err }
+
+// ValidationError is returned when a write is rejected by the annotation
+// schema. Fields are sorted by key.
+type ValidationError struct {
+	Bucket string
+	Key    string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.Error()
+	}
+	return fmt.Sprintf("metadata: invalid annotations on %s/%s: %s", e.Bucket, e.Key, strings.Join(fields, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+// Unwrap returns the field errors, so errors.Is(err, ErrUnknownAnnotation)
+// tells whether any annotation was unknown.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Fields))
+	for i, f := range e.Fields {
+		errs[i] = f
+	}
+	return errs
+}
+
+// Schema maps annotation keys to the descriptors their values must parse with.
+// It is safe for concurrent use.
+type Schema struct {
+	mu          sync.RWMutex
+	descriptors map[constant.AnnotationKey]constant.AnnotationValidator
+}
+
+// NewSchema returns a schema holding descriptors.
+func NewSchema(descriptors ...constant.AnnotationValidator) *Schema {
+	s := &Schema{descriptors: make(map[constant.AnnotationKey]constant.AnnotationValidator)}
+	s.Register(descriptors...)
+	return s
+}
+
+// Register adds descriptors, replacing any registered for the same key.
+func (s *Schema) Register(descriptors ...constant.AnnotationValidator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range descriptors {
+		s.descriptors[d.AnnotationKey()] = d
+	}
+}
+
+// Lookup returns the descriptor registered for key.
+func (s *Schema) Lookup(key constant.AnnotationKey) (constant.AnnotationValidator, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.descriptors[key]
+	return d, ok
+}
+
+// Validate checks annotations against their descriptors and returns the
+// failures sorted by key. With strict, annotations without a descriptor fail
+// with ErrUnknownAnnotation; otherwise they are accepted.
+func (s *Schema) Validate(annotations map[string]string, strict bool) []FieldError {
+	var fields []FieldError
+	for _, k := range slices.Sorted(maps.Keys(annotations)) {
+		key, value := constant.AnnotationKey(k), annotations[k]
+		d, ok := s.Lookup(key)
+		if !ok {
+			if strict {
+				fields = append(fields, FieldError{Key: key, Value: value, Err: ErrUnknownAnnotation})
+			}
+			continue
+		}
+		if err := d.Validate(value); err != nil {
+			fields = append(fields, FieldError{Key: key, Value: value, Err: err})
+		}
+	}
+	return fields
+}
+
+// Schema returns the annotation schema used to validate writes. It starts
+// with the descriptors of every annotation in libs/constant; services register
+// their own with Schema().Register.
+func (c *Client) Schema() *Schema { return c.schema }
+
+// validate checks the annotations next adds or changes compared to current,
+// as configured by Config.AnnotationValidation. Values already stored are not
+// checked again, so tightening the schema does not block unrelated writes.
+func (s *Store) validate(key string, current, next *Metadata) error {
+	c := s.client
+	mode := c.config.AnnotationValidation
+	if mode == ValidationOff {
+		return nil
+	}
+	changed := make(map[string]string)
+	for k, v := range next.Annotations {
+		if old, ok := current.Annotations[k]; !ok || old != v {
+			changed[k] = v
+		}
+	}
+	fields := c.schema.Validate(changed, c.config.StrictAnnotations)
+	if len(fields) == 0 {
+		return nil
+	}
+	err := &ValidationError{Bucket: s.backend.Bucket(), Key: key, Fields: fields}
+	if mode == ValidationWarn {
+		c.logger.Warn("Writing annotations that failed validation", "bucket", err.Bucket, "key", key, "error", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package metadata_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func newValidatingClient(t *testing.T, storage metadata.Storage, mode metadata.ValidationMode, strict bool) *metadata.Client {
+	t.Helper()
+	cfg := metadatatest.NewConfig("")
+	cfg.AnnotationValidation = mode
+	cfg.StrictAnnotations = strict
+	client := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+	client.Schema().Register(stageDesc)
+	return client
+}
+
+// stageDesc is an enum annotation for the tests, since none of the generated
+// descriptors is one.
+var stageDesc = constant.NewEnumAnnotationDesc("stage", []string{"alpha", "beta"})
+
+func TestValidationRejectsInvalidAnnotations(t *testing.T) {
+	client := newValidatingClient(t, metadata.NewMemoryStorage(), metadata.ValidationReject, false)
+	const uuid = "8f0c2a4e-0000-4000-8000-000000000001"
+
+	err := client.UpdatePlayer(uuid, func(m *metadata.Metadata) {
+		m.SetAnnotation(constant.PlayerOnline, "yes")
+		m.SetAnnotation("note", "anything goes without a descriptor")
+	})
+	var verr *metadata.ValidationError
+	if !errors.As(err, &verr) || !errors.Is(err, metadata.ErrValidation) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if len(verr.Fields) != 1 || verr.Fields[0].Key != constant.PlayerOnline || verr.Fields[0].Value != "yes" {
+		t.Fatalf("unexpected field errors %+v", verr.Fields)
+	}
+	if _, err := client.PlayerHistory(t.Context(), uuid); !errors.Is(err, metadata.ErrNotFound) {
+		t.Fatalf("a rejected write must not be stored, got %v", err)
+	}
+
+	if err := client.UpdatePlayer(uuid, func(m *metadata.Metadata) { metadata.Set(m, constant.PlayerOnlineDesc, true) }); err != nil {
+		t.Fatalf("valid update failed: %v", err)
+	}
+	// Patches go through the same check.
+	if _, err := client.PatchPlayer(t.Context(), uuid, metadata.MergePatch, []byte(`{"annotations":{"player/online":"maybe"}}`)); !errors.Is(err, metadata.ErrValidation) {
+		t.Fatalf("expected a patch to be validated, got %v", err)
+	}
+}
+
+func TestValidationWarnWritesAnyway(t *testing.T) {
+	client := newValidatingClient(t, metadata.NewMemoryStorage(), metadata.ValidationWarn, true)
+
+	if err := client.UpdateServer("lobby", func(m *metadata.Metadata) {
+		m.SetAnnotation(stageDesc.Key, "on fire")
+		m.SetAnnotation("unknown", "x")
+	}); err != nil {
+		t.Fatalf("warn mode should not fail the write: %v", err)
+	}
+	history, err := client.ServerHistory(t.Context(), "lobby")
+	if err != nil || len(history) != 1 || !history[0].Value.HasAnnotation(stageDesc.Key, "on fire") {
+		t.Fatalf("expected the invalid value to be written, got %+v (%v)", history, err)
+	}
+}
+
+func TestStrictValidationRejectsUnknownAnnotations(t *testing.T) {
+	client := newValidatingClient(t, metadata.NewMemoryStorage(), metadata.ValidationReject, true)
+
+	err := client.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetAnnotation("motd", "hello") })
+	if !errors.Is(err, metadata.ErrUnknownAnnotation) {
+		t.Fatalf("expected ErrUnknownAnnotation, got %v", err)
+	}
+
+	client.Schema().Register(constant.NewStringAnnotationDesc("motd"))
+	if err := client.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetAnnotation("motd", "hello") }); err != nil {
+		t.Fatalf("registered annotation rejected: %v", err)
+	}
+}
+
+func TestValidationOnlyChecksChangedAnnotations(t *testing.T) {
+	storage := metadata.NewMemoryStorage()
+	legacy := newValidatingClient(t, storage, metadata.ValidationOff, false)
+	strict := newValidatingClient(t, storage, metadata.ValidationReject, true)
+
+	if err := legacy.UpdateServer("lobby", func(m *metadata.Metadata) {
+		m.SetAnnotation(stageDesc.Key, "gamma")
+		m.SetAnnotation("current_players", "3")
+	}); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	// Values written before validation was enabled do not block other changes.
+	if err := strict.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("unrelated update rejected: %v", err)
+	}
+	if err := strict.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetAnnotation("current_players", "4") }); !errors.Is(err, metadata.ErrUnknownAnnotation) {
+		t.Fatalf("expected a changed unknown annotation to be rejected, got %v", err)
+	}
+}
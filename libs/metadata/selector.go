@@ -0,0 +1,221 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Operator is the relation a Requirement checks between a label and its values.
+type Operator string
+
+const (
+	OpEquals       Operator = "="
+	OpNotEquals    Operator = "!="
+	OpIn           Operator = "in"
+	OpNotIn        Operator = "notin"
+	OpExists       Operator = "exists"
+	OpDoesNotExist Operator = "!"
+)
+
+// Requirement is a single term of a Selector, e.g. `region in (eu,us)`.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Matches reports whether labels satisfy the requirement. As in Kubernetes,
+// `!=` and `notin` also match objects that do not carry the label at all.
+// `=` and `!=` requirements without a value match nothing.
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, exists := labels[r.Key]
+	switch r.Operator {
+	case OpEquals:
+		return len(r.Values) > 0 && exists && value == r.Values[0]
+	case OpNotEquals:
+		return len(r.Values) > 0 && (!exists || value != r.Values[0])
+	case OpIn:
+		return exists && r.hasValue(value)
+	case OpNotIn:
+		return !exists || !r.hasValue(value)
+	case OpExists:
+		return exists
+	case OpDoesNotExist:
+		return !exists
+	default:
+		return false
+	}
+}
+
+func (r Requirement) hasValue(value string) bool {
+	for _, v := range r.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case OpExists:
+		return r.Key
+	case OpDoesNotExist:
+		return "!" + r.Key
+	case OpIn, OpNotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+	default:
+		var value string
+		if len(r.Values) > 0 {
+			value = r.Values[0]
+		}
+		return r.Key + string(r.Operator) + value
+	}
+}
+
+// Selector is a conjunction of requirements. The empty selector matches everything.
+type Selector []Requirement
+
+// Matches reports whether labels satisfy every requirement.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether the selector has no requirements.
+func (s Selector) Empty() bool { return len(s) == 0 }
+
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// SelectorFromLabels builds an equality selector matching all of labels.
+func SelectorFromLabels(labels map[string]string) Selector {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := make(Selector, 0, len(keys))
+	for _, k := range keys {
+		s = append(s, Requirement{Key: k, Operator: OpEquals, Values: []string{labels[k]}})
+	}
+	return s
+}
+
+var (
+	setRequirementRe = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+	labelKeyRe       = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+)
+
+// ParseSelector parses a Kubernetes-style label selector such as
+// `region in (eu-west,us-east),tier!=free,!banned`. Supported terms:
+//
+//	key=value, key==value, key!=value
+//	key in (v1,v2), key notin (v1,v2)
+//	key (exists), !key (does not exist)
+func ParseSelector(selector string) (Selector, error) {
+	terms, err := splitSelectorTerms(selector)
+	if err != nil {
+		return nil, err
+	}
+	s := make(Selector, 0, len(terms))
+	for _, term := range terms {
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+		s = append(s, r)
+	}
+	return s, nil
+}
+
+// splitSelectorTerms splits on commas that are not inside a value set.
+func splitSelectorTerms(selector string) ([]string, error) {
+	var terms []string
+	depth, start := 0, 0
+	for i, ch := range selector {
+		switch ch {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("invalid selector %q: nested parentheses", selector)
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("invalid selector %q: unbalanced parentheses", selector)
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid selector %q: unbalanced parentheses", selector)
+	}
+	terms = append(terms, selector[start:])
+
+	// An entirely blank selector means "everything".
+	if len(terms) == 1 && strings.TrimSpace(terms[0]) == "" {
+		return nil, nil
+	}
+	return terms, nil
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return Requirement{}, fmt.Errorf("empty requirement")
+	}
+
+	if m := setRequirementRe.FindStringSubmatch(term); m != nil {
+		values := strings.Split(m[3], ",")
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+		if len(values) == 1 && values[0] == "" {
+			return Requirement{}, fmt.Errorf("%q: empty value set", term)
+		}
+		return newRequirement(m[1], Operator(m[2]), values)
+	}
+
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		return newRequirement(strings.TrimSpace(term[1:]), OpDoesNotExist, nil)
+	}
+
+	for _, op := range []struct {
+		token    string
+		operator Operator
+	}{{"!=", OpNotEquals}, {"==", OpEquals}, {"=", OpEquals}} {
+		if key, value, found := strings.Cut(term, op.token); found {
+			return newRequirement(strings.TrimSpace(key), op.operator, []string{strings.TrimSpace(value)})
+		}
+	}
+
+	return newRequirement(term, OpExists, nil)
+}
+
+func newRequirement(key string, op Operator, values []string) (Requirement, error) {
+	if !labelKeyRe.MatchString(key) {
+		return Requirement{}, fmt.Errorf("invalid label key %q", key)
+	}
+	for _, v := range values {
+		if strings.ContainsAny(v, "=!() ") {
+			return Requirement{}, fmt.Errorf("invalid label value %q for key %q", v, key)
+		}
+	}
+	return Requirement{Key: key, Operator: op, Values: values}, nil
+}
@@ -0,0 +1,112 @@
+package metadata
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "tier=gold", want: "tier=gold"},
+		{in: "tier==gold", want: "tier=gold"},
+		{in: " tier != free ", want: "tier!=free"},
+		{in: "region in (eu-west, us-east)", want: "region in (eu-west,us-east)"},
+		{in: "region notin (eu-west)", want: "region notin (eu-west)"},
+		{in: "banned", want: "banned"},
+		{in: "!banned", want: "!banned"},
+		{in: "region in (eu-west,us-east),tier!=free,!banned", want: "region in (eu-west,us-east),tier!=free,!banned"},
+		{in: "game/mode=survival", want: "game/mode=survival"},
+		{in: "region in ()", wantErr: true},
+		{in: "region in (eu", wantErr: true},
+		{in: "region in ((eu))", wantErr: true},
+		{in: "tier=gold,", wantErr: true},
+		{in: "=gold", wantErr: true},
+		{in: "tier=go ld", wantErr: true},
+		{in: "bad key", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSelector(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSelector(%q) = %q, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSelector(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseSelector(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"region": "eu-west", "tier": "gold"}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"region=eu-west", true},
+		{"region=us-east", false},
+		{"region!=us-east", true},
+		{"missing!=x", true},
+		{"region in (eu-west,us-east)", true},
+		{"region in (us-east)", false},
+		{"missing in (x)", false},
+		{"region notin (us-east)", true},
+		{"region notin (eu-west)", false},
+		{"missing notin (x)", true},
+		{"tier", true},
+		{"banned", false},
+		{"!banned", true},
+		{"!tier", false},
+		{"region in (eu-west,us-east),tier!=free,!banned", true},
+		{"region in (eu-west,us-east),tier!=gold", false},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q) failed: %v", tt.selector, err)
+		}
+		if got := sel.Matches(labels); got != tt.want {
+			t.Errorf("%q.Matches(%v) = %v, want %v", tt.selector, labels, got, tt.want)
+		}
+	}
+}
+
+func TestRequirementWithoutValue(t *testing.T) {
+	labels := map[string]string{"tier": "gold"}
+	for _, r := range []Requirement{
+		{Key: "tier", Operator: OpEquals},
+		{Key: "tier", Operator: OpNotEquals},
+		{Key: "missing", Operator: OpNotEquals},
+	} {
+		if r.Matches(labels) {
+			t.Errorf("%q without a value matched %v", r, labels)
+		}
+		if want := r.Key + string(r.Operator); r.String() != want {
+			t.Errorf("String() = %q, want %q", r.String(), want)
+		}
+	}
+}
+
+func TestSelectorFromLabels(t *testing.T) {
+	sel := SelectorFromLabels(map[string]string{"tier": "gold", "region": "eu"})
+	if sel.String() != "region=eu,tier=gold" {
+		t.Fatalf("unexpected selector %q", sel)
+	}
+	if !sel.Matches(map[string]string{"tier": "gold", "region": "eu", "extra": "x"}) {
+		t.Fatalf("expected superset of labels to match")
+	}
+	if sel.Matches(map[string]string{"tier": "gold"}) {
+		t.Fatalf("expected missing label not to match")
+	}
+	if !SelectorFromLabels(nil).Matches(nil) {
+		t.Fatalf("expected empty selector to match everything")
+	}
+}
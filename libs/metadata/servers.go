@@ -1,51 +1,38 @@
 package metadata
 
-import "encoding/json"
+import "context"
 
 func (c *Client) GetServer(name string) (*Metadata, bool) {
-	c.serversMu.RLock()
-	defer c.serversMu.RUnlock()
-	server, exists := c.serversCache[name]
-	return server, exists
+	return c.servers.Get(name)
 }
 
 func (c *Client) GetAllServers() map[string]*Metadata {
-	c.serversMu.RLock()
-	defer c.serversMu.RUnlock()
-	result := make(map[string]*Metadata)
-	for k, v := range c.serversCache {
-		result[k] = v
-	}
-	return result
+	return c.servers.List()
 }
 
+// UpdateServer applies updateFunc to the latest stored value of the server with
+// the same optimistic concurrency guarantees as UpdatePlayer.
 func (c *Client) UpdateServer(name string, updateFunc func(*Metadata)) error {
-	c.serversMu.Lock()
-	defer c.serversMu.Unlock()
-
-	server, exists := c.serversCache[name]
-	if !exists {
-		server = &Metadata{}
-	}
-
-	serverCopy := &Metadata{Labels: make(map[string]string), Annotations: make(map[string]string)}
-	if server.Labels != nil {
-		for k, v := range server.Labels {
-			serverCopy.Labels[k] = v
-		}
-	}
-	if server.Annotations != nil {
-		for k, v := range server.Annotations {
-			serverCopy.Annotations[k] = v
-		}
-	}
-
-	updateFunc(serverCopy)
-
-	data, err := json.Marshal(serverCopy)
-	if err != nil {
-		return err
-	}
-	_, err = c.serversKV.Put(name, data)
-	return err
+	return c.servers.Update(name, updateFunc)
+}
+
+// UpdateServerContext is UpdateServer bounded by ctx.
+func (c *Client) UpdateServerContext(ctx context.Context, name string, updateFunc func(*Metadata)) error {
+	return c.servers.UpdateContext(ctx, name, updateFunc)
+}
+
+// UpdateServerAndWait is UpdateServer that returns once the local cache holds
+// the write, together with the resulting metadata. See Store.UpdateAndWait.
+func (c *Client) UpdateServerAndWait(ctx context.Context, name string, updateFunc func(*Metadata)) (*Metadata, error) {
+	return c.servers.UpdateAndWait(ctx, name, updateFunc)
+}
+
+// DeleteServer removes a server. ErrNotFound is returned if it does not exist.
+func (c *Client) DeleteServer(name string, opts ...DeleteOption) error {
+	return c.servers.Delete(name, opts...)
+}
+
+// DeleteServerContext is DeleteServer bounded by ctx.
+func (c *Client) DeleteServerContext(ctx context.Context, name string, opts ...DeleteOption) error {
+	return c.servers.DeleteContext(ctx, name, opts...)
 }
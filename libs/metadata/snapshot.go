@@ -0,0 +1,399 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// SnapshotFormat identifies the header line of a snapshot.
+const SnapshotFormat = "stellaroot-metadata-snapshot"
+
+// SnapshotVersion is the snapshot version written by ExportSnapshot. Import
+// accepts this version and older ones.
+const SnapshotVersion = 1
+
+// SnapshotHeader is the first line of a snapshot.
+type SnapshotHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Revisions holds the bucket revision each kind was exported at.
+	Revisions map[string]uint64 `json:"revisions,omitempty"`
+}
+
+// SnapshotObject is one object of a snapshot; every line after the header is
+// one of them.
+type SnapshotObject struct {
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Revision uint64    `json:"revision,omitempty"` // informational, not restored
+	Metadata *Metadata `json:"metadata"`
+}
+
+// ExportOptions selects what ExportSnapshot writes.
+type ExportOptions struct {
+	// Kinds to export. Empty means players and servers.
+	Kinds []string
+	// Selector only exports objects whose labels match it.
+	Selector Selector
+}
+
+// ImportMode decides what ImportSnapshot does with existing objects.
+type ImportMode int
+
+const (
+	// ImportMerge creates missing objects and merges the labels, annotations
+	// and owner references of the snapshot into existing ones. Nothing is
+	// deleted.
+	ImportMerge ImportMode = iota
+	// ImportReplace makes every imported kind match the snapshot: objects are
+	// written exactly as in the snapshot, and objects missing from it are
+	// deleted. With a selector, only matching objects are touched.
+	ImportReplace
+)
+
+func (m ImportMode) String() string {
+	switch m {
+	case ImportMerge:
+		return "merge"
+	case ImportReplace:
+		return "replace"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseImportMode parses "merge" or "replace".
+func ParseImportMode(s string) (ImportMode, error) {
+	switch s {
+	case "merge":
+		return ImportMerge, nil
+	case "replace":
+		return ImportReplace, nil
+	default:
+		return 0, fmt.Errorf("invalid import mode %q, want merge or replace", s)
+	}
+}
+
+// ImportOptions controls ImportSnapshot.
+type ImportOptions struct {
+	Mode ImportMode
+	// DryRun computes the changes without writing anything.
+	DryRun bool
+	// Kinds to import. Empty means every kind in the snapshot.
+	Kinds []string
+	// Selector only imports snapshot objects whose labels match it and, in
+	// ImportReplace mode, only deletes existing objects that match it.
+	Selector Selector
+}
+
+// ImportAction is what ImportSnapshot did, or would do, to an object.
+type ImportAction int
+
+const (
+	ImportUnchanged ImportAction = iota
+	ImportCreated
+	ImportUpdated
+	ImportDeleted
+)
+
+func (a ImportAction) String() string {
+	switch a {
+	case ImportUnchanged:
+		return "unchanged"
+	case ImportCreated:
+		return "created"
+	case ImportUpdated:
+		return "updated"
+	case ImportDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// ImportChange is the outcome for one object.
+type ImportChange struct {
+	Kind   string
+	Key    string
+	Action ImportAction
+}
+
+// ImportResult lists the outcome for every object considered, in snapshot
+// order followed by deletions.
+type ImportResult struct {
+	DryRun  bool
+	Changes []ImportChange
+}
+
+// Count returns how many objects got action.
+func (r *ImportResult) Count(action ImportAction) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// ExportSnapshot writes the selected kinds to w as NDJSON: a SnapshotHeader
+// line followed by one SnapshotObject per line, sorted by kind then key. It
+// reads the buckets rather than the caches, so it includes every write
+// acknowledged before the call.
+func (c *Client) ExportSnapshot(ctx context.Context, w io.Writer, opts ExportOptions) error {
+	kinds := opts.Kinds
+	if len(kinds) == 0 {
+		kinds = []string{KindPlayers, KindServers}
+	}
+	stores := make([]*Store, 0, len(kinds))
+	for _, kind := range kinds {
+		s, ok := c.Store(kind)
+		if !ok {
+			return fmt.Errorf("unknown resource kind %q", kind)
+		}
+		stores = append(stores, s)
+	}
+
+	header := SnapshotHeader{
+		Format:    SnapshotFormat,
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Revisions: make(map[string]uint64),
+	}
+	objects := make([][]SnapshotObject, len(stores))
+	for i, s := range stores {
+		list, err := s.read(ctx, opts.Selector)
+		if err != nil {
+			return err
+		}
+		for _, key := range slices.Sorted(maps.Keys(list)) {
+			m := list[key]
+			objects[i] = append(objects[i], SnapshotObject{Kind: s.kind.Name, Key: key, Revision: m.Revision, Metadata: m})
+			header.Revisions[s.kind.Name] = max(header.Revisions[s.kind.Name], m.Revision)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+	for _, list := range objects {
+		for _, obj := range list {
+			if err := enc.Encode(obj); err != nil {
+				return fmt.Errorf("failed to write %s/%s: %w", obj.Kind, obj.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// read returns the objects of the bucket whose labels match selector, read
+// from the backend.
+func (s *Store) read(ctx context.Context, selector Selector) (map[string]*Metadata, error) {
+	keys, err := s.backend.Keys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.backend.Bucket(), err)
+	}
+	objects := make(map[string]*Metadata, len(keys))
+	for _, key := range keys {
+		m, err := s.load(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil && selector.Matches(m.Labels) {
+			objects[key] = m
+		}
+	}
+	return objects, nil
+}
+
+// load returns the latest value of key from the backend, or nil if it is
+// absent or deleted.
+func (s *Store) load(ctx context.Context, key string) (*Metadata, error) {
+	entry, err := s.backend.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, err := decodeEntry(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s/%s: %w", s.backend.Bucket(), key, err)
+	}
+	return m, nil
+}
+
+// ReadSnapshot decodes a snapshot written by ExportSnapshot. It fails on an
+// unknown format or a newer version.
+func ReadSnapshot(r io.Reader) (SnapshotHeader, []SnapshotObject, error) {
+	dec := json.NewDecoder(r)
+	var header SnapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return header, nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if header.Format != SnapshotFormat {
+		return header, nil, fmt.Errorf("not a metadata snapshot (format %q)", header.Format)
+	}
+	if header.Version < 1 || header.Version > SnapshotVersion {
+		return header, nil, fmt.Errorf("unsupported snapshot version %d, this client reads up to %d", header.Version, SnapshotVersion)
+	}
+
+	var objects []SnapshotObject
+	for line := 2; ; line++ {
+		var obj SnapshotObject
+		err := dec.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			return header, objects, nil
+		}
+		if err != nil {
+			return header, nil, fmt.Errorf("failed to read snapshot object %d: %w", line, err)
+		}
+		if obj.Kind == "" || obj.Key == "" || obj.Metadata == nil {
+			return header, nil, fmt.Errorf("snapshot object %d needs a kind, a key and metadata", line)
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// ImportSnapshot applies a snapshot to the registered kinds. The whole
+// snapshot is read and checked before anything is written. Writes go through
+// the regular update path, so they are conditional and stamped with this
+// client's changed-by; CreatedAt of new objects is the import time.
+// On error, the result holds the changes made so far.
+func (c *Client) ImportSnapshot(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	_, objects, err := ReadSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := func(kind string) bool { return len(opts.Kinds) == 0 || slices.Contains(opts.Kinds, kind) }
+	stores := make(map[string]*Store)
+	var kinds []string // import order: as first seen in the snapshot, then opts.Kinds
+	for _, kind := range append(objectKinds(objects), opts.Kinds...) {
+		if _, seen := stores[kind]; seen || !wanted(kind) {
+			continue
+		}
+		s, ok := c.Store(kind)
+		if !ok {
+			return nil, fmt.Errorf("unknown resource kind %q", kind)
+		}
+		stores[kind] = s
+		kinds = append(kinds, kind)
+	}
+
+	result := &ImportResult{DryRun: opts.DryRun}
+	imported := make(map[string]map[string]bool)
+	for _, obj := range objects {
+		s, ok := stores[obj.Kind]
+		if !ok || !opts.Selector.Matches(obj.Metadata.Labels) {
+			continue
+		}
+		if imported[obj.Kind] == nil {
+			imported[obj.Kind] = make(map[string]bool)
+		}
+		imported[obj.Kind][obj.Key] = true
+
+		action, err := s.importObject(ctx, obj, opts)
+		if err != nil {
+			return result, fmt.Errorf("failed to import %s/%s: %w", obj.Kind, obj.Key, err)
+		}
+		result.Changes = append(result.Changes, ImportChange{Kind: obj.Kind, Key: obj.Key, Action: action})
+	}
+
+	if opts.Mode != ImportReplace {
+		return result, nil
+	}
+	for _, kind := range kinds {
+		s := stores[kind]
+		existing, err := s.read(ctx, opts.Selector)
+		if err != nil {
+			return result, err
+		}
+		for _, key := range slices.Sorted(maps.Keys(existing)) {
+			if imported[kind][key] {
+				continue
+			}
+			if !opts.DryRun {
+				if err := s.DeleteContext(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+					return result, fmt.Errorf("failed to delete %s/%s: %w", kind, key, err)
+				}
+			}
+			result.Changes = append(result.Changes, ImportChange{Kind: kind, Key: key, Action: ImportDeleted})
+		}
+	}
+	return result, nil
+}
+
+func objectKinds(objects []SnapshotObject) []string {
+	var kinds []string
+	for _, obj := range objects {
+		if !slices.Contains(kinds, obj.Kind) {
+			kinds = append(kinds, obj.Kind)
+		}
+	}
+	return kinds
+}
+
+// importObject writes one snapshot object according to opts.Mode.
+func (s *Store) importObject(ctx context.Context, obj SnapshotObject, opts ImportOptions) (ImportAction, error) {
+	apply := func(m *Metadata) {
+		if opts.Mode == ImportReplace {
+			clear(m.Labels)
+			clear(m.Annotations)
+			m.OwnerReferences = nil
+		}
+		maps.Copy(m.Labels, obj.Metadata.Labels)
+		maps.Copy(m.Annotations, obj.Metadata.Annotations)
+		for _, owner := range obj.Metadata.OwnerReferences {
+			m.SetOwner(owner.Kind, owner.Key)
+		}
+	}
+
+	// Plan against the bucket rather than the cache, which may lag behind.
+	current, err := s.load(ctx, obj.Key)
+	if err != nil {
+		return 0, err
+	}
+	action := ImportCreated
+	if current != nil {
+		next := current.DeepCopy()
+		if next.Labels == nil {
+			next.Labels = make(map[string]string)
+		}
+		if next.Annotations == nil {
+			next.Annotations = make(map[string]string)
+		}
+		apply(next)
+		if sameContent(current, next) {
+			return ImportUnchanged, nil
+		}
+		action = ImportUpdated
+	}
+
+	if opts.DryRun {
+		return action, nil
+	}
+	return action, s.UpdateContext(ctx, obj.Key, apply)
+}
+
+// sameContent compares labels, annotations and owner references, ignoring
+// who wrote them.
+func sameContent(a, b *Metadata) bool {
+	annotations := func(m *Metadata) map[string]string {
+		out := maps.Clone(m.Annotations)
+		delete(out, string(constant.ChangedBy))
+		return out
+	}
+	return maps.Equal(a.Labels, b.Labels) && maps.Equal(annotations(a), annotations(b)) &&
+		slices.Equal(a.OwnerReferences, b.OwnerReferences)
+}
@@ -0,0 +1,163 @@
+package metadata_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func seedSnapshotClient(t *testing.T) *metadata.Client {
+	t.Helper()
+	client := metadatatest.NewMemoryClient(t)
+	for name, mode := range map[string]string{"lobby-1": "lobby", "survival-1": "survival", "survival-2": "survival"} {
+		if err := client.UpdateServer(name, func(m *metadata.Metadata) {
+			m.SetLabel("mode", mode)
+			m.SetAnnotation("motd", "welcome to "+name)
+		}); err != nil {
+			t.Fatalf("UpdateServer failed: %v", err)
+		}
+	}
+	if err := client.UpdatePlayer("8f0c2a4e-0000-4000-8000-000000000001", func(m *metadata.Metadata) { m.SetLabel("tier", "premium") }); err != nil {
+		t.Fatalf("UpdatePlayer failed: %v", err)
+	}
+	return client
+}
+
+func exportSnapshot(t *testing.T, client *metadata.Client, opts metadata.ExportOptions) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := client.ExportSnapshot(context.Background(), &buf, opts); err != nil {
+		t.Fatalf("ExportSnapshot failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportSnapshotWithSelector(t *testing.T) {
+	client := seedSnapshotClient(t)
+
+	all := exportSnapshot(t, client, metadata.ExportOptions{})
+	if lines := strings.Count(string(all), "\n"); lines != 5 {
+		t.Fatalf("expected a header and 4 objects, got %d lines:\n%s", lines, all)
+	}
+
+	selector, err := metadata.ParseSelector("mode=survival")
+	if err != nil {
+		t.Fatalf("ParseSelector failed: %v", err)
+	}
+	header, objects, err := metadata.ReadSnapshot(bytes.NewReader(exportSnapshot(t, client, metadata.ExportOptions{
+		Kinds:    []string{metadata.KindServers},
+		Selector: selector,
+	})))
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	if header.Version != metadata.SnapshotVersion || header.Revisions[metadata.KindServers] == 0 {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if len(objects) != 2 || objects[0].Key != "survival-1" || objects[1].Key != "survival-2" {
+		t.Fatalf("expected the two survival servers in key order, got %+v", objects)
+	}
+}
+
+func TestImportSnapshotMerge(t *testing.T) {
+	ctx := context.Background()
+	snapshot := exportSnapshot(t, seedSnapshotClient(t), metadata.ExportOptions{})
+
+	target := metadatatest.NewMemoryClient(t)
+	if err := target.UpdateServer("lobby-1", func(m *metadata.Metadata) { m.SetLabel("region", "eu") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	if err := target.UpdateServer("creative-1", func(m *metadata.Metadata) { m.SetLabel("mode", "creative") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+
+	dry, err := target.ImportSnapshot(ctx, bytes.NewReader(snapshot), metadata.ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry-run ImportSnapshot failed: %v", err)
+	}
+	if dry.Count(metadata.ImportCreated) != 3 || dry.Count(metadata.ImportUpdated) != 1 {
+		t.Fatalf("unexpected dry-run plan %+v", dry.Changes)
+	}
+	if _, ok := target.GetServer("survival-1"); ok {
+		t.Fatalf("a dry run must not write")
+	}
+
+	result, err := target.ImportSnapshot(ctx, bytes.NewReader(snapshot), metadata.ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportSnapshot failed: %v", err)
+	}
+	if result.Count(metadata.ImportDeleted) != 0 {
+		t.Fatalf("merge must not delete, got %+v", result.Changes)
+	}
+	lobby, err := target.Servers().UpdateAndWait(ctx, "lobby-1", func(*metadata.Metadata) {})
+	if err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if lobby.Labels["region"] != "eu" || lobby.Labels["mode"] != "lobby" {
+		t.Fatalf("expected merged labels, got %v", lobby.Labels)
+	}
+	if _, ok := target.GetServer("creative-1"); !ok {
+		t.Fatalf("merge must keep objects missing from the snapshot")
+	}
+
+	again, err := target.ImportSnapshot(ctx, bytes.NewReader(snapshot), metadata.ImportOptions{})
+	if err != nil {
+		t.Fatalf("second ImportSnapshot failed: %v", err)
+	}
+	if again.Count(metadata.ImportUnchanged) != len(again.Changes) {
+		t.Fatalf("re-importing should change nothing, got %+v", again.Changes)
+	}
+}
+
+func TestImportSnapshotReplace(t *testing.T) {
+	ctx := context.Background()
+	snapshot := exportSnapshot(t, seedSnapshotClient(t), metadata.ExportOptions{Kinds: []string{metadata.KindServers}})
+
+	target := metadatatest.NewMemoryClient(t)
+	for name, mode := range map[string]string{"lobby-1": "lobby", "survival-9": "survival"} {
+		if err := target.UpdateServer(name, func(m *metadata.Metadata) {
+			m.SetLabel("mode", mode)
+			m.SetLabel("stale", "true")
+		}); err != nil {
+			t.Fatalf("UpdateServer failed: %v", err)
+		}
+	}
+
+	selector, _ := metadata.ParseSelector("mode=survival")
+	result, err := target.ImportSnapshot(ctx, bytes.NewReader(snapshot), metadata.ImportOptions{
+		Mode:     metadata.ImportReplace,
+		Selector: selector,
+	})
+	if err != nil {
+		t.Fatalf("ImportSnapshot failed: %v", err)
+	}
+	if result.Count(metadata.ImportCreated) != 2 || result.Count(metadata.ImportDeleted) != 1 {
+		t.Fatalf("unexpected result %+v", result.Changes)
+	}
+	if err := target.Servers().WaitForSync(ctx); err != nil {
+		t.Fatalf("WaitForSync failed: %v", err)
+	}
+	if _, err := target.Servers().UpdateAndWait(ctx, "survival-1", func(*metadata.Metadata) {}); err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if _, ok := target.GetServer("survival-9"); ok {
+		t.Fatalf("replace should delete matching objects missing from the snapshot")
+	}
+	if lobby, ok := target.GetServer("lobby-1"); !ok || lobby.Labels["stale"] != "true" {
+		t.Fatalf("replace must not touch objects outside the selector, got %v", lobby)
+	}
+}
+
+func TestReadSnapshotRejectsNewerVersion(t *testing.T) {
+	_, _, err := metadata.ReadSnapshot(strings.NewReader(`{"format":"stellaroot-metadata-snapshot","version":99}` + "\n"))
+	if err == nil || !strings.Contains(err.Error(), "unsupported snapshot version 99") {
+		t.Fatalf("expected an unsupported version error, got %v", err)
+	}
+	if _, _, err := metadata.ReadSnapshot(strings.NewReader(`{"format":"something-else","version":1}`)); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,116 @@
+package metadata
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// ConditionStatus is whether a condition holds.
+type ConditionStatus string
+
+const (
+	ConditionTrue    ConditionStatus = "True"
+	ConditionFalse   ConditionStatus = "False"
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+// Condition is one aspect of an object's observed state, e.g. a server's
+// "Ready" condition, in the style of Kubernetes status conditions.
+type Condition struct {
+	// Type names the condition, in CamelCase, e.g. "Ready".
+	Type   string          `json:"type"`
+	Status ConditionStatus `json:"status"`
+	// Reason is a CamelCase cause for the last transition, e.g.
+	// "WorldLoading"; Message a human-readable explanation.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is when Status last changed.
+	LastTransitionTime time.Time `json:"last_transition_time,omitzero"`
+}
+
+// Status is the observed state of an object (see Metadata.Status).
+type Status struct {
+	// ObservedGeneration is the Metadata.Generation the status was computed
+	// from, set by the writer of the status.
+	ObservedGeneration int64       `json:"observed_generation,omitempty"`
+	Conditions         []Condition `json:"conditions,omitempty"`
+	// CurrentPlayers is the number of players connected to a server.
+	CurrentPlayers int `json:"current_players,omitempty"`
+}
+
+// GetCondition returns the condition of conditionType.
+func (s Status) GetCondition(conditionType string) (Condition, bool) {
+	for _, c := range s.Conditions {
+		if c.Type == conditionType {
+			return c, true
+		}
+	}
+	return Condition{}, false
+}
+
+// IsConditionTrue reports whether the condition of conditionType is present
+// and True.
+func (s Status) IsConditionTrue(conditionType string) bool {
+	c, ok := s.GetCondition(conditionType)
+	return ok && c.Status == ConditionTrue
+}
+
+// SetCondition adds c, or replaces the condition of the same type. Unless c
+// sets it, LastTransitionTime is now for new conditions and changed statuses,
+// and kept from the replaced condition otherwise. It reports whether anything
+// changed.
+func (s *Status) SetCondition(c Condition) bool {
+	i := slices.IndexFunc(s.Conditions, func(existing Condition) bool { return existing.Type == c.Type })
+	if i < 0 {
+		if c.LastTransitionTime.IsZero() {
+			c.LastTransitionTime = time.Now().UTC()
+		}
+		s.Conditions = append(s.Conditions, c)
+		return true
+	}
+	existing := s.Conditions[i]
+	if c.LastTransitionTime.IsZero() {
+		c.LastTransitionTime = existing.LastTransitionTime
+		if c.Status != existing.Status {
+			c.LastTransitionTime = time.Now().UTC()
+		}
+	}
+	s.Conditions[i] = c
+	return c.Status != existing.Status || c.Reason != existing.Reason || c.Message != existing.Message ||
+		!c.LastTransitionTime.Equal(existing.LastTransitionTime)
+}
+
+// RemoveCondition removes the condition of conditionType, reporting whether
+// it was present.
+func (s *Status) RemoveCondition(conditionType string) bool {
+	n := len(s.Conditions)
+	s.Conditions = slices.DeleteFunc(s.Conditions, func(c Condition) bool { return c.Type == conditionType })
+	return len(s.Conditions) != n
+}
+
+// UpdatePlayerStatus updates the status of a player. See Store.UpdateStatus.
+func (c *Client) UpdatePlayerStatus(ctx context.Context, uuid string, updateFunc func(*Status)) (*Metadata, error) {
+	return c.players.UpdateStatus(ctx, uuid, updateFunc)
+}
+
+// UpdateServerStatus updates the status of a server. See Store.UpdateStatus.
+func (c *Client) UpdateServerStatus(ctx context.Context, name string, updateFunc func(*Status)) (*Metadata, error) {
+	return c.servers.UpdateStatus(ctx, name, updateFunc)
+}
+
+// UpdateStatus is the status counterpart of Update: a read-modify-write of
+// the status of key that leaves its labels, annotations and generation as
+// they are, so the owner of an object can report on it without racing the
+// users editing it. Like the Kubernetes status subresource it does not
+// create objects: ErrNotFound is returned for an absent key. Status writes
+// are not sent to admission hooks, but stamp the changed-by annotation like
+// any other write so the history credits the right writer. It returns the
+// written object.
+func (s *Store) UpdateStatus(ctx context.Context, key string, updateFunc func(*Status)) (*Metadata, error) {
+	return s.write(ctx, key, 0, false, func(_, next *Metadata) error {
+		updateFunc(&next.Status)
+		stampChangedBy(ctx, s.client.config, next)
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func TestUpdateStatusLeavesSpecAlone(t *testing.T) {
+	ctx := context.Background()
+	storage := metadata.NewMemoryStorage()
+	cfg := metadatatest.NewConfig("")
+	cfg.ChangedBy = "dashboard"
+	dashboard := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+	cfg = metadatatest.NewConfig("")
+	cfg.ChangedBy = "lobby"
+	gameServer := metadatatest.NewMemoryClientWithConfig(t, storage, cfg)
+
+	if _, err := gameServer.UpdateServerStatus(ctx, "lobby", func(*metadata.Status) {}); !errors.Is(err, metadata.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an absent server, got %v", err)
+	}
+	if err := dashboard.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetLabel("game_mode", "survival") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+
+	m, err := gameServer.UpdateServerStatus(ctx, "lobby", func(s *metadata.Status) {
+		s.ObservedGeneration = 1
+		s.SetCondition(metadata.Condition{Type: "Ready", Status: metadata.ConditionFalse, Reason: "WorldLoading"})
+	})
+	if err != nil {
+		t.Fatalf("UpdateServerStatus failed: %v", err)
+	}
+	if m.Generation != 1 || !m.HasLabel("game_mode", "survival") {
+		t.Fatalf("a status write must not touch the spec, got %+v", m)
+	}
+	if !m.HasAnnotation(constant.ChangedBy, "lobby") {
+		t.Fatalf("a status write must be credited to its writer, got %+v", m.Annotations)
+	}
+
+	history, err := dashboard.ServerHistory(ctx, "lobby")
+	if err != nil {
+		t.Fatalf("ServerHistory failed: %v", err)
+	}
+	last := history[len(history)-1]
+	if last.ChangedBy != "lobby" {
+		t.Fatalf("expected the status revision to be credited to lobby, got %q", last.ChangedBy)
+	}
+	d := last.Diff
+	if len(d.Labels) != 0 || len(d.Annotations) != 0 || len(d.Conditions) != 1 || d.Conditions[0].NewValue != "False (WorldLoading)" {
+		t.Fatalf("expected only a condition change, got %+v", d)
+	}
+}
+
+func TestUpdateKeepsStatus(t *testing.T) {
+	ctx := context.Background()
+	client := metadatatest.NewMemoryClient(t)
+
+	if err := client.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetLabel("game_mode", "survival") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	if _, err := client.UpdateServerStatus(ctx, "lobby", func(s *metadata.Status) {
+		s.SetCondition(metadata.Condition{Type: "Ready", Status: metadata.ConditionTrue})
+	}); err != nil {
+		t.Fatalf("UpdateServerStatus failed: %v", err)
+	}
+
+	m, err := client.Servers().UpdateAndWait(ctx, "lobby", func(m *metadata.Metadata) {
+		m.SetLabel("game_mode", "creative")
+		m.Status.Conditions = nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if !m.Status.IsConditionTrue("Ready") {
+		t.Fatalf("updates must not change the status, got %+v", m.Status)
+	}
+	if m.Generation != 2 {
+		t.Fatalf("expected the label change to bump the generation to 2, got %d", m.Generation)
+	}
+
+	m, err = client.Servers().UpdateAndWait(ctx, "lobby", func(*metadata.Metadata) {})
+	if err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if m.Generation != 2 {
+		t.Fatalf("a write without spec changes must keep the generation, got %d", m.Generation)
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	var s metadata.Status
+	if !s.SetCondition(metadata.Condition{Type: "Ready", Status: metadata.ConditionFalse, Reason: "Starting"}) {
+		t.Fatalf("adding a condition is a change")
+	}
+	added, _ := s.GetCondition("Ready")
+	if added.LastTransitionTime.IsZero() {
+		t.Fatalf("expected a transition time to be set")
+	}
+
+	if !s.SetCondition(metadata.Condition{Type: "Ready", Status: metadata.ConditionFalse, Reason: "WorldLoading"}) {
+		t.Fatalf("a new reason is a change")
+	}
+	if c, _ := s.GetCondition("Ready"); !c.LastTransitionTime.Equal(added.LastTransitionTime) {
+		t.Fatalf("the transition time must be kept while the status holds")
+	}
+	if s.SetCondition(metadata.Condition{Type: "Ready", Status: metadata.ConditionFalse, Reason: "WorldLoading"}) {
+		t.Fatalf("setting the same condition is not a change")
+	}
+
+	time.Sleep(time.Millisecond)
+	s.SetCondition(metadata.Condition{Type: "Ready", Status: metadata.ConditionTrue})
+	if c, _ := s.GetCondition("Ready"); !c.LastTransitionTime.After(added.LastTransitionTime) || !s.IsConditionTrue("Ready") {
+		t.Fatalf("expected a new transition, got %+v", c)
+	}
+	if !s.RemoveCondition("Ready") || len(s.Conditions) != 0 {
+		t.Fatalf("RemoveCondition failed: %+v", s)
+	}
+}
@@ -0,0 +1,189 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// Built-in kind names.
+const (
+	KindPlayers = "players"
+	KindServers = "servers"
+)
+
+// ResourceKind describes a type of metadata object kept in its own KV bucket.
+type ResourceKind struct {
+	// Name identifies the kind (e.g. "players"). It is used for lookups,
+	// watcher status and logs.
+	Name string
+	// Bucket is the KV bucket holding the objects of this kind.
+	Bucket string
+	// NameAnnotation, when set, makes the store keep a name -> key lookup built
+	// from this annotation (see Store.GetByName).
+	NameAnnotation constant.AnnotationKey
+}
+
+// Store holds the cache, watcher and write path for a single ResourceKind.
+type Store struct {
+	client  *Client
+	kind    ResourceKind
+	backend Backend
+
+	mu       sync.RWMutex
+	revision uint64        // last bucket revision applied by the watcher
+	advanced chan struct{} // closed and replaced whenever revision moves
+	cache    map[string]*Metadata
+	names    map[string]string // maps NameAnnotation value to key
+
+	synced   chan struct{} // closed once the initial values are loaded
+	syncOnce sync.Once
+
+	subsMu sync.Mutex
+	subs   map[*Subscription]struct{}
+
+	labelIndex map[string]index // label key -> value -> keys
+	indexers   map[string]IndexFunc
+	indices    map[string]index
+}
+
+func newStore(c *Client, kind ResourceKind, backend Backend) *Store {
+	return &Store{
+		client:     c,
+		kind:       kind,
+		backend:    backend,
+		cache:      make(map[string]*Metadata),
+		names:      make(map[string]string),
+		synced:     make(chan struct{}),
+		advanced:   make(chan struct{}),
+		subs:       make(map[*Subscription]struct{}),
+		labelIndex: make(map[string]index),
+		indexers:   make(map[string]IndexFunc),
+		indices:    make(map[string]index),
+	}
+}
+
+// RegisterKind creates (or opens) the bucket for kind and starts a watcher that
+// loads it into a new store. The store is filled asynchronously; use
+// Store.WaitForSync before relying on its contents. Each kind name can only be
+// registered once.
+func (c *Client) RegisterKind(kind ResourceKind) (*Store, error) {
+	if kind.Name == "" || kind.Bucket == "" {
+		return nil, errors.New("resource kind needs a name and a bucket")
+	}
+
+	c.storesMu.Lock()
+	defer c.storesMu.Unlock()
+	if _, exists := c.stores[kind.Name]; exists {
+		return nil, fmt.Errorf("resource kind %q is already registered", kind.Name)
+	}
+
+	backend, err := c.storage.Open(c.ctx, c.bucketConfig(kind.Bucket))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create/get %s bucket: %w", kind.Name, err)
+	}
+
+	s := newStore(c, kind, backend)
+	c.stores[kind.Name] = s
+	c.wg.Add(1)
+	go s.watch()
+
+	return s, nil
+}
+
+// bucketConfig returns the settings of a metadata bucket from Config.
+func (c *Client) bucketConfig(bucket string) BucketConfig {
+	return BucketConfig{
+		Bucket:       bucket,
+		History:      c.config.BucketHistory,
+		TTL:          c.config.BucketTTL,
+		Replicas:     c.config.BucketReplicas,
+		Storage:      c.config.BucketStorage,
+		MaxValueSize: c.config.BucketMaxValueSize,
+		Reconcile:    c.config.ReconcileBuckets,
+	}
+}
+
+// Store returns the store of a registered kind.
+func (c *Client) Store(kind string) (*Store, bool) {
+	c.storesMu.RLock()
+	defer c.storesMu.RUnlock()
+	s, ok := c.stores[kind]
+	return s, ok
+}
+
+// Players returns the store backing the player methods.
+func (c *Client) Players() *Store { return c.players }
+
+// Servers returns the store backing the server methods.
+func (c *Client) Servers() *Store { return c.servers }
+
+// Kind returns the kind this store was registered with.
+func (s *Store) Kind() ResourceKind { return s.kind }
+
+// Get returns a copy of the cached object stored under key.
+func (s *Store) Get(key string) (*Metadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, exists := s.cache[key]
+	return m.DeepCopy(), exists
+}
+
+// GetByName looks an object up by the value of the kind's NameAnnotation.
+func (s *Store) GetByName(name string) (*Metadata, bool) {
+	key, exists := s.KeyForName(name)
+	if !exists {
+		return nil, false
+	}
+	return s.Get(key)
+}
+
+// KeyForName resolves the value of the kind's NameAnnotation to a key.
+func (s *Store) KeyForName(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, exists := s.names[name]
+	return key, exists
+}
+
+// List returns a copy of every cached object keyed by its key.
+func (s *Store) List() map[string]*Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string]*Metadata, len(s.cache))
+	for k, v := range s.cache {
+		result[k] = v.DeepCopy()
+	}
+	return result
+}
+
+// nameOf returns the value of the kind's NameAnnotation on m, if any.
+func (s *Store) nameOf(m *Metadata) string {
+	if s.kind.NameAnnotation == "" || m == nil {
+		return ""
+	}
+	name, _ := m.GetAnnotation(s.kind.NameAnnotation)
+	return name
+}
+
+// setLocked stores m under key and keeps the name lookup and indexes in sync.
+// m may be nil to remove the key. Callers must hold s.mu.
+func (s *Store) setLocked(key string, m *Metadata) {
+	if old, exists := s.cache[key]; exists {
+		if name := s.nameOf(old); name != "" && s.names[name] == key {
+			delete(s.names, name)
+		}
+		s.indexLocked(key, old, true)
+	}
+	if m == nil {
+		delete(s.cache, key)
+		return
+	}
+	s.cache[key] = m
+	if name := s.nameOf(m); name != "" {
+		s.names[name] = key
+	}
+	s.indexLocked(key, m, false)
+}
@@ -0,0 +1,290 @@
+package metadata
+
+import (
+	"sync"
+)
+
+// OverflowPolicy decides what a subscription does with a change when its
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards the change and counts it in SubscriptionStats.Dropped.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock makes the watcher wait for room. A slow subscriber then
+	// delays every subscriber of the same kind, and the cache itself.
+	OverflowBlock
+	// OverflowCoalesce merges every change into the one already queued for the
+	// same key, full queue or not, so the subscriber sees one event from the
+	// queued OldValue to the latest NewValue. A change for a key with nothing
+	// queued is dropped when the queue is full.
+	OverflowCoalesce
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDrop:
+		return "drop"
+	case OverflowBlock:
+		return "block"
+	case OverflowCoalesce:
+		return "coalesce"
+	default:
+		return "unknown"
+	}
+}
+
+// defaultSubscriptionBuffer is the queue size used without WithBufferSize.
+const defaultSubscriptionBuffer = 256
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	name     string
+	buffer   int
+	overflow OverflowPolicy
+	selector Selector
+}
+
+// WithSubscriptionName names the subscription in stats and logs.
+func WithSubscriptionName(name string) SubscribeOption {
+	return func(o *subscribeOptions) { o.name = name }
+}
+
+// WithBufferSize sets how many changes can wait for the callback.
+func WithBufferSize(n int) SubscribeOption {
+	return func(o *subscribeOptions) { o.buffer = n }
+}
+
+// WithOverflow sets what happens when the queue is full. Defaults to OverflowDrop.
+func WithOverflow(policy OverflowPolicy) SubscribeOption {
+	return func(o *subscribeOptions) { o.overflow = policy }
+}
+
+// WithSelector only delivers changes whose old or new labels match selector,
+// so a subscriber also sees an object leave the selection.
+func WithSelector(selector Selector) SubscribeOption {
+	return func(o *subscribeOptions) { o.selector = selector }
+}
+
+// SubscriptionStats is a point-in-time view of a subscription queue.
+type SubscriptionStats struct {
+	Name      string
+	Kind      string
+	Overflow  OverflowPolicy
+	Depth     int // changes waiting for the callback
+	Capacity  int
+	Delivered uint64
+	Dropped   uint64
+	Coalesced uint64
+}
+
+// Subscription delivers the changes of one store to a callback, in revision
+// order, from its own goroutine and queue.
+type Subscription struct {
+	store *Store
+	cb    MetadataChangeCallback
+	opts  subscribeOptions
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	queue       []MetadataChangeEvent
+	closed      bool
+	overflowing bool
+	delivered   uint64
+	dropped     uint64
+	coalesced   uint64
+}
+
+// SubscribeToPlayerChanges registers a callback for player metadata changes.
+func (c *Client) SubscribeToPlayerChanges(cb MetadataChangeCallback, opts ...SubscribeOption) (unsubscribe func()) {
+	return c.players.Subscribe(cb, opts...)
+}
+
+// SubscribeToServerChanges registers a callback for server metadata changes.
+func (c *Client) SubscribeToServerChanges(cb MetadataChangeCallback, opts ...SubscribeOption) (unsubscribe func()) {
+	return c.servers.Subscribe(cb, opts...)
+}
+
+// Subscribe registers a callback for changes to objects of this store's kind.
+// It is a shorthand for NewSubscription when the stats are not needed.
+func (s *Store) Subscribe(cb MetadataChangeCallback, opts ...SubscribeOption) (unsubscribe func()) {
+	return s.NewSubscription(cb, opts...).Unsubscribe
+}
+
+// NewSubscription starts delivering changes to cb. Callbacks run one at a
+// time on the subscription's goroutine, never on the watcher's, so a slow
+// callback only fills its own queue.
+func (s *Store) NewSubscription(cb MetadataChangeCallback, opts ...SubscribeOption) *Subscription {
+	o := subscribeOptions{buffer: defaultSubscriptionBuffer}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.buffer <= 0 {
+		o.buffer = defaultSubscriptionBuffer
+	}
+
+	sub := &Subscription{store: s, cb: cb, opts: o}
+	sub.cond = sync.NewCond(&sub.mu)
+
+	s.subsMu.Lock()
+	s.subs[sub] = struct{}{}
+	s.subsMu.Unlock()
+
+	go sub.run()
+	return sub
+}
+
+// Unsubscribe stops delivery and discards queued changes. A callback that is
+// already running is not interrupted. It is safe to call more than once.
+func (sub *Subscription) Unsubscribe() {
+	s := sub.store
+	s.subsMu.Lock()
+	delete(s.subs, sub)
+	s.subsMu.Unlock()
+
+	sub.mu.Lock()
+	sub.closed = true
+	sub.queue = nil
+	sub.cond.Broadcast()
+	sub.mu.Unlock()
+}
+
+// Stats returns the current queue depth and counters.
+func (sub *Subscription) Stats() SubscriptionStats {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return SubscriptionStats{
+		Name:      sub.opts.name,
+		Kind:      sub.store.kind.Name,
+		Overflow:  sub.opts.overflow,
+		Depth:     len(sub.queue),
+		Capacity:  sub.opts.buffer,
+		Delivered: sub.delivered,
+		Dropped:   sub.dropped,
+		Coalesced: sub.coalesced,
+	}
+}
+
+// SubscriptionStats returns the stats of every live subscription of the store.
+func (s *Store) SubscriptionStats() []SubscriptionStats {
+	s.subsMu.Lock()
+	subs := make([]*Subscription, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.subsMu.Unlock()
+
+	stats := make([]SubscriptionStats, 0, len(subs))
+	for _, sub := range subs {
+		stats = append(stats, sub.Stats())
+	}
+	return stats
+}
+
+// publish hands event to every subscription whose selector matches it.
+func (s *Store) publish(event MetadataChangeEvent) {
+	s.subsMu.Lock()
+	subs := make([]*Subscription, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.subsMu.Unlock()
+
+	for _, sub := range subs {
+		if sub.matches(event) {
+			sub.enqueue(event)
+		}
+	}
+}
+
+// closeSubscriptions stops every subscription, releasing a watcher blocked on
+// an OverflowBlock queue.
+func (s *Store) closeSubscriptions() {
+	s.subsMu.Lock()
+	subs := make([]*Subscription, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.subsMu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+func (sub *Subscription) matches(event MetadataChangeEvent) bool {
+	sel := sub.opts.selector
+	if sel == nil {
+		return true
+	}
+	return (event.OldValue != nil && sel.Matches(event.OldValue.Labels)) ||
+		(event.NewValue != nil && sel.Matches(event.NewValue.Labels))
+}
+
+func (sub *Subscription) enqueue(event MetadataChangeEvent) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+
+	switch sub.opts.overflow {
+	case OverflowBlock:
+		for len(sub.queue) >= sub.opts.buffer && !sub.closed {
+			sub.cond.Wait()
+		}
+		if sub.closed {
+			return
+		}
+	case OverflowCoalesce:
+		for i := range sub.queue {
+			if queued := &sub.queue[i]; queued.Key == event.Key {
+				event.OldValue = queued.OldValue
+				*queued = event
+				sub.coalesced++
+				return
+			}
+		}
+		fallthrough
+	default:
+		if len(sub.queue) >= sub.opts.buffer {
+			sub.dropped++
+			if !sub.overflowing {
+				sub.overflowing = true
+				sub.store.client.logger.Warn("Subscription queue full, dropping changes",
+					"kind", sub.store.kind.Name, "subscription", sub.opts.name, "capacity", sub.opts.buffer, "policy", sub.opts.overflow.String())
+			}
+			return
+		}
+	}
+
+	sub.queue = append(sub.queue, event)
+	sub.cond.Broadcast()
+}
+
+func (sub *Subscription) run() {
+	for {
+		sub.mu.Lock()
+		for len(sub.queue) == 0 && !sub.closed {
+			sub.overflowing = false
+			sub.cond.Wait()
+		}
+		if sub.closed {
+			sub.mu.Unlock()
+			return
+		}
+		event := sub.queue[0]
+		sub.queue[0] = MetadataChangeEvent{}
+		sub.queue = sub.queue[1:]
+		sub.cond.Broadcast()
+		sub.mu.Unlock()
+
+		sub.cb(event)
+
+		sub.mu.Lock()
+		sub.delivered++
+		sub.mu.Unlock()
+	}
+}
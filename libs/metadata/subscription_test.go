@@ -0,0 +1,148 @@
+package metadata_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+)
+
+func setServerLabel(t *testing.T, client *metadata.Client, name, key, value string) {
+	t.Helper()
+	if _, err := client.UpdateServerAndWait(context.Background(), name, func(m *metadata.Metadata) { m.SetLabel(key, value) }); err != nil {
+		t.Fatalf("UpdateServerAndWait failed: %v", err)
+	}
+}
+
+func waitForStats(t *testing.T, sub *metadata.Subscription, cond func(metadata.SubscriptionStats) bool) metadata.SubscriptionStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st := sub.Stats()
+		if cond(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for subscription stats, last %+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSlowSubscriberDoesNotStallOthers(t *testing.T) {
+	client := metadatatest.NewMemoryClient(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	slow := client.Servers().NewSubscription(func(metadata.MetadataChangeEvent) { <-release },
+		metadata.WithSubscriptionName("slow"), metadata.WithBufferSize(1))
+	defer slow.Unsubscribe()
+
+	fast := make(chan metadata.MetadataChangeEvent, 32)
+	unsub := client.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) { fast <- e })
+	defer unsub()
+
+	const writes = 10
+	for i := 0; i < writes; i++ {
+		setServerLabel(t, client, "lobby", "round", fmt.Sprint(i))
+	}
+	for i := 0; i < writes; i++ {
+		e := waitForEvent(t, fast, "lobby")
+		if got := e.NewValue.Labels["round"]; got != fmt.Sprint(i) {
+			t.Fatalf("event %d out of order: round=%s", i, got)
+		}
+	}
+
+	// At most one change is in the callback and one queued; the rest are dropped.
+	st := waitForStats(t, slow, func(st metadata.SubscriptionStats) bool { return st.Dropped >= writes-2 })
+	if st.Name != "slow" || st.Kind != metadata.KindServers || st.Depth > 1 || st.Capacity != 1 || st.Delivered != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if len(client.Servers().SubscriptionStats()) != 2 {
+		t.Fatalf("expected two live subscriptions")
+	}
+}
+
+func TestCoalescingSubscriberSeesLatestValue(t *testing.T) {
+	client := metadatatest.NewMemoryClient(t)
+
+	release := make(chan struct{})
+	events := make(chan metadata.MetadataChangeEvent, 8)
+	sub := client.Servers().NewSubscription(func(e metadata.MetadataChangeEvent) {
+		<-release
+		events <- e
+	}, metadata.WithOverflow(metadata.OverflowCoalesce))
+	defer sub.Unsubscribe()
+
+	setServerLabel(t, client, "lobby", "v", "0") // held in the callback
+	waitForStats(t, sub, func(st metadata.SubscriptionStats) bool { return st.Depth == 0 })
+	for i := 1; i <= 3; i++ {
+		setServerLabel(t, client, "lobby", "v", fmt.Sprint(i))
+	}
+	st := waitForStats(t, sub, func(st metadata.SubscriptionStats) bool { return st.Coalesced == 2 })
+	if st.Depth != 1 || st.Dropped != 0 {
+		t.Fatalf("expected one coalesced change queued, got %+v", st)
+	}
+	close(release)
+
+	if e := waitForEvent(t, events, "lobby"); e.NewValue.Labels["v"] != "0" {
+		t.Fatalf("expected first change first, got %+v", e.NewValue)
+	}
+	e := waitForEvent(t, events, "lobby")
+	if e.OldValue.Labels["v"] != "0" || e.NewValue.Labels["v"] != "3" {
+		t.Fatalf("expected change from v=0 to v=3, got %v -> %v", e.OldValue.Labels, e.NewValue.Labels)
+	}
+}
+
+func TestBlockingSubscriberGetsEveryChange(t *testing.T) {
+	client := metadatatest.NewMemoryClient(t)
+
+	events := make(chan metadata.MetadataChangeEvent, 32)
+	unsub := client.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) {
+		time.Sleep(time.Millisecond)
+		events <- e
+	}, metadata.WithBufferSize(1), metadata.WithOverflow(metadata.OverflowBlock))
+	defer unsub()
+
+	for i := 0; i < 10; i++ {
+		if err := client.UpdateServer("lobby", func(m *metadata.Metadata) { m.SetLabel("round", fmt.Sprint(i)) }); err != nil {
+			t.Fatalf("UpdateServer failed: %v", err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if e := waitForEvent(t, events, "lobby"); e.NewValue.Labels["round"] != fmt.Sprint(i) {
+			t.Fatalf("expected round %d, got %+v", i, e.NewValue)
+		}
+	}
+}
+
+func TestSubscriptionSelector(t *testing.T) {
+	client := metadatatest.NewMemoryClient(t)
+
+	sel, err := metadata.ParseSelector("mode=lobby")
+	if err != nil {
+		t.Fatalf("ParseSelector failed: %v", err)
+	}
+	events := make(chan metadata.MetadataChangeEvent, 8)
+	unsub := client.SubscribeToServerChanges(func(e metadata.MetadataChangeEvent) { events <- e }, metadata.WithSelector(sel))
+	defer unsub()
+
+	setServerLabel(t, client, "lobby-1", "mode", "lobby")
+	setServerLabel(t, client, "game-1", "mode", "game")
+	setServerLabel(t, client, "lobby-1", "mode", "game") // leaves the selection
+
+	if e := waitForEvent(t, events, "lobby-1"); !e.NewValue.HasLabel("mode", "lobby") {
+		t.Fatalf("expected lobby-1 entering the selection, got %+v", e)
+	}
+	if e := waitForEvent(t, events, "lobby-1"); !e.NewValue.HasLabel("mode", "game") {
+		t.Fatalf("expected lobby-1 leaving the selection, got %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
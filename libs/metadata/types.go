@@ -3,6 +3,8 @@ package metadata
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/bafbi/stellaroot/libs/constant"
 )
@@ -10,6 +12,64 @@ import (
 type Metadata struct {
 	Labels      map[string]string `json:"labels,omitempty"`      // User-defined labels for organization/filtering
 	Annotations map[string]string `json:"annotations,omitempty"` // System/tool-defined metadata
+
+	// CreatedAt is stamped by the client when the entry is first written and is
+	// preserved by later updates.
+	CreatedAt time.Time `json:"created_at,omitzero"`
+
+	// Generation counts the writes that changed labels, annotations or owner
+	// references, the user-facing spec of the object. Status writes leave it
+	// alone.
+	Generation int64 `json:"generation,omitempty"`
+
+	// Status is the state observed by the object's owner, e.g. a game server
+	// reporting its conditions. It is only written by UpdateStatus; updates
+	// and patches keep it as stored.
+	Status Status `json:"status,omitzero"`
+
+	// OwnerReferences name the objects this one belongs to, e.g. the server
+	// hosting a world. Once all of its owners are gone the garbage collector
+	// deletes it (see GarbageCollector).
+	OwnerReferences []OwnerReference `json:"owner_references,omitempty"`
+
+	// Deletion is set while a foreground or orphan deletion of the object
+	// waits for the garbage collector. Updates and patches keep it as stored.
+	Deletion *Deletion `json:"deletion,omitempty"`
+
+	// Revision and UpdatedAt describe the KV entry this value was read from.
+	// They are filled in by the client and never stored in the value itself.
+	Revision  uint64    `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// DeepCopy returns a copy of m that shares no maps with it. Values handed out
+// by the client cache are copies, so callers may modify them freely; changes
+// only reach NATS through an update.
+func (m *Metadata) DeepCopy() *Metadata {
+	if m == nil {
+		return nil
+	}
+	out := *m
+	out.Labels = copyStringMap(m.Labels)
+	out.Annotations = copyStringMap(m.Annotations)
+	out.Status.Conditions = slices.Clone(m.Status.Conditions)
+	out.OwnerReferences = slices.Clone(m.OwnerReferences)
+	if m.Deletion != nil {
+		deletion := *m.Deletion
+		out.Deletion = &deletion
+	}
+	return &out
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
 }
 
 // Label methods
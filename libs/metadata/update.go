@@ -0,0 +1,197 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/constant"
+)
+
+// defaultMaxUpdateAttempts is used when Config.MaxUpdateAttempts is not set.
+const defaultMaxUpdateAttempts = 5
+
+// Update performs a read-modify-write of key using the entry revision as a
+// precondition. On a revision conflict the latest value is re-read and
+// updateFunc is applied again, so updateFunc must be safe to call more than once.
+// A *ConflictError is returned once Config.MaxUpdateAttempts is exhausted.
+func (s *Store) Update(key string, updateFunc func(*Metadata)) error {
+	return s.UpdateContext(context.Background(), key, updateFunc)
+}
+
+// UpdateContext is Update with a context bounding the KV round-trips.
+func (s *Store) UpdateContext(ctx context.Context, key string, updateFunc func(*Metadata)) error {
+	_, err := s.update(ctx, key, updateFunc)
+	return err
+}
+
+// UpdateAndWait is UpdateContext followed by waiting until the watcher has applied the
+// write to the cache, so reads right after it see the new value. It returns
+// the cached object, which may already be newer than the write. If ctx ends
+// first the write has still happened and ctx.Err() is returned.
+func (s *Store) UpdateAndWait(ctx context.Context, key string, updateFunc func(*Metadata)) (*Metadata, error) {
+	revision, err := s.update(ctx, key, updateFunc)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.WaitForRevision(ctx, revision); err != nil {
+		return nil, err
+	}
+	m, exists := s.Get(key)
+	if !exists {
+		// Deleted by someone else after our write.
+		return nil, ErrNotFound
+	}
+	return m, nil
+}
+
+type changedByKey struct{}
+
+// WithChangedBy overrides Config.ChangedBy for the updates made with ctx, e.g.
+// to record the user behind a dashboard request.
+func WithChangedBy(ctx context.Context, changedBy string) context.Context {
+	return context.WithValue(ctx, changedByKey{}, changedBy)
+}
+
+// stampChangedBy sets the changed-by annotation of m to the writer's identity.
+// A writer without one removes it, so a revision is never attributed to the
+// previous writer.
+func stampChangedBy(ctx context.Context, config *Config, m *Metadata) {
+	changedBy := config.ChangedBy
+	if v, ok := ctx.Value(changedByKey{}).(string); ok {
+		changedBy = v
+	}
+	if changedBy == "" {
+		m.DeleteAnnotation(constant.ChangedBy)
+		return
+	}
+	m.SetAnnotation(constant.ChangedBy, changedBy)
+}
+
+// update runs the Update loop and returns the revision it wrote.
+func (s *Store) update(ctx context.Context, key string, updateFunc func(*Metadata)) (uint64, error) {
+	written, err := s.modify(ctx, key, 0, func(m *Metadata) error {
+		updateFunc(m)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return written.Revision, nil
+}
+
+// modify is the read-modify-write loop behind Update and Patch. Each attempt
+// runs modifyFunc, the mutating admission hooks, the annotation schema and the
+// validating admission hooks; an error from any of them aborts without
+// writing. Changes to the status and the deletion marker are dropped, and the
+// generation is bumped if labels, annotations or owner references changed. A
+// non-zero expected revision must be the latest revision of key (0 for an
+// absent key is not checked); a mismatch returns a *ConflictError without
+// retrying. It returns the written object with its new revision.
+func (s *Store) modify(ctx context.Context, key string, expected uint64, modifyFunc func(*Metadata) error) (*Metadata, error) {
+	return s.write(ctx, key, expected, true, func(current, next *Metadata) error {
+		if err := modifyFunc(next); err != nil {
+			return err
+		}
+		// The status is only written by UpdateStatus, the deletion marker
+		// by Delete.
+		next.Status, next.Deletion = current.Status, current.Deletion
+		stampChangedBy(ctx, s.client.config, next)
+		if err := s.admit(ctx, MutatingHook, key, current, next); err != nil {
+			return err
+		}
+		if err := s.validate(key, current, next); err != nil {
+			return err
+		}
+		if err := s.admit(ctx, ValidatingHook, key, current, next); err != nil {
+			return err
+		}
+		next.Generation = current.Generation
+		if current.Revision == 0 || !sameContent(current, next) {
+			next.Generation++
+		}
+		return nil
+	})
+}
+
+// write is the compare-and-set loop shared by spec and status writes. prepare
+// turns a copy of the stored object (empty if absent) into the value to
+// write; an error aborts without writing. Without create an absent key
+// returns ErrNotFound. See modify for expected.
+func (s *Store) write(ctx context.Context, key string, expected uint64, create bool, prepare func(current, next *Metadata) error) (*Metadata, error) {
+	c, backend := s.client, s.backend
+	attempts := c.config.MaxUpdateAttempts
+	if attempts <= 0 {
+		attempts = defaultMaxUpdateAttempts
+	}
+
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		current := &Metadata{}
+
+		entry, err := backend.Get(ctx, key)
+		switch {
+		case err == nil:
+			current, err = decodeEntry(entry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal %s/%s: %w", backend.Bucket(), key, err)
+			}
+		case errors.Is(err, ErrNotFound):
+			// Absent or deleted: the write below creates it.
+			if !create {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+		if expected != 0 && current.Revision != expected {
+			return nil, &ConflictError{Bucket: backend.Bucket(), Key: key, Attempts: i + 1, Err: ErrRevisionMismatch}
+		}
+
+		next := current.DeepCopy()
+		if next.Labels == nil {
+			next.Labels = make(map[string]string)
+		}
+		if next.Annotations == nil {
+			next.Annotations = make(map[string]string)
+		}
+		if err := prepare(current, next); err != nil {
+			return nil, err
+		}
+
+		// CreatedAt is owned by the client, not by prepare.
+		next.CreatedAt = current.CreatedAt
+		if current.Revision == 0 {
+			next.CreatedAt = time.Now().UTC()
+		}
+
+		data, err := json.Marshal(next)
+		if err != nil {
+			return nil, err
+		}
+
+		var written uint64
+		if current.Revision == 0 {
+			written, err = backend.Create(ctx, key, data)
+		} else {
+			written, err = backend.Update(ctx, key, data, current.Revision)
+		}
+		if err == nil {
+			next.Revision, next.UpdatedAt = written, time.Now().UTC()
+			return next, nil
+		}
+		if !errors.Is(err, ErrRevisionMismatch) {
+			return nil, err
+		}
+
+		lastErr = err
+		if expected != 0 {
+			return nil, &ConflictError{Bucket: backend.Bucket(), Key: key, Attempts: i + 1, Err: err}
+		}
+		c.logger.Debug("Revision conflict, retrying update", "bucket", backend.Bucket(), "key", key, "attempt", i+1)
+	}
+
+	return nil, &ConflictError{Bucket: backend.Bucket(), Key: key, Attempts: attempts, Err: lastErr}
+}
@@ -1,194 +1,142 @@
 package metadata
 
 import (
-	"encoding/json"
+	"errors"
 	"time"
-
-	"github.com/nats-io/nats.go"
-
-	"github.com/bafbi/stellaroot/libs/constant"
 )
 
-// SubscribeToPlayerChanges registers a callback for player metadata changes.
-func (c *Client) SubscribeToPlayerChanges(cb MetadataChangeCallback) (unsubscribe func()) {
-	c.eventBus.Subscribe(PlayerChangeEventKey, cb)
-	return func() { c.eventBus.Unsubscribe(PlayerChangeEventKey, cb) }
-}
-
-// SubscribeToServerChanges registers a callback for server metadata changes.
-func (c *Client) SubscribeToServerChanges(cb MetadataChangeCallback) (unsubscribe func()) {
-	c.eventBus.Subscribe(ServerChangeEventKey, cb)
-	return func() { c.eventBus.Unsubscribe(ServerChangeEventKey, cb) }
-}
-
 // WatcherStatusChan returns a channel for watcher health status updates.
 func (c *Client) WatcherStatusChan() <-chan WatcherStatus { return c.watcherStatusCh }
 
-func (c *Client) watchPlayers() {
-	defer c.wg.Done()
+// reportWatcherStatus never blocks. When nobody keeps up with the channel the
+// oldest status is dropped, so the latest state is always delivered.
+func (c *Client) reportWatcherStatus(status WatcherStatus) {
 	for {
-		watcher, err := c.playersKV.WatchAll()
-		if err != nil {
-			c.logger.Error("Failed to create players watcher", "error", err)
-			select {
-			case c.watcherStatusCh <- WatcherStatus{Watcher: "players", Healthy: false, Error: err}:
-			default:
-			}
-			select {
-			case <-time.After(2 * time.Second):
-				continue
-			case <-c.ctx.Done():
-				return
-			}
-		}
 		select {
-		case c.watcherStatusCh <- WatcherStatus{Watcher: "players", Healthy: true, Error: nil}:
+		case c.watcherStatusCh <- status:
+			return
 		default:
 		}
-		defer watcher.Stop()
-
-		for {
-			select {
-			case entry := <-watcher.Updates():
-				if entry == nil {
-					continue
-				}
-
-				uuid := entry.Key()
-				var oldValue *Metadata
-				var changeType ChangeType
-
-				if entry.Operation() == nats.KeyValueDelete {
-					var oldName string
-					c.playersMu.RLock()
-					if oldPlayer, exists := c.playersCache[uuid]; exists && oldPlayer.Annotations != nil {
-						oldName, _ = oldPlayer.Annotations[string(constant.PlayerUsername)]
-						oldValue = oldPlayer
-					}
-					c.playersMu.RUnlock()
-
-					c.playersMu.Lock()
-					if oldName != "" {
-						delete(c.playersNameToUUID, oldName)
-					}
-					delete(c.playersCache, uuid)
-					c.playersMu.Unlock()
-
-					changeType = ChangeTypeDelete
-
-					c.eventBus.Publish(PlayerChangeEventKey, MetadataChangeEvent{Key: uuid, OldValue: oldValue, NewValue: nil, Type: changeType})
-					continue
-				}
-
-				var player Metadata
-				if err := json.Unmarshal(entry.Value(), &player); err != nil {
-					c.logger.Warn("Failed to unmarshal player metadata", "error", err)
-					continue
-				}
-
-				var oldName, newName string
-				c.playersMu.RLock()
-				if oldPlayer, exists := c.playersCache[uuid]; exists && oldPlayer.Annotations != nil {
-					oldName, _ = oldPlayer.Annotations[string(constant.PlayerUsername)]
-					oldValue = oldPlayer
-				}
-				c.playersMu.RUnlock()
-				if player.Annotations != nil {
-					newName, _ = player.Annotations[string(constant.PlayerUsername)]
-				}
-
-				c.playersMu.Lock()
-				if oldName != "" {
-					delete(c.playersNameToUUID, oldName)
-				}
-				if newName != "" {
-					c.playersNameToUUID[newName] = uuid
-				}
-				c.playersCache[uuid] = &player
-				c.playersMu.Unlock()
-
-				changeType = ChangeTypePut
-				c.eventBus.Publish(PlayerChangeEventKey, MetadataChangeEvent{Key: uuid, OldValue: oldValue, NewValue: &player, Type: changeType})
-
-			case <-c.ctx.Done():
-				return
-			}
+		select {
+		case <-c.watcherStatusCh:
+		default:
 		}
 	}
 }
 
-func (c *Client) watchServers() {
+// watchRetryDelay is how long watch waits before recreating a failed watcher.
+var watchRetryDelay = 2 * time.Second
+
+var errWatcherClosed = errors.New("watcher closed")
+
+// watch keeps the cache in sync until the client is closed. When the watcher
+// fails it is stopped and a new one resumes after the last applied revision,
+// so subscribers only see the changes they missed.
+func (s *Store) watch() {
+	c := s.client
 	defer c.wg.Done()
 	for {
-		watcher, err := c.serversKV.WatchAll()
-		if err != nil {
-			c.logger.Error("Failed to create servers watcher", "error", err)
-			select {
-			case c.watcherStatusCh <- WatcherStatus{Watcher: "servers", Healthy: false, Error: err}:
-			default:
+		err := s.watchOnce()
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.logger.Error("Watcher failed", "kind", s.kind.Name, "error", err)
+		c.reportWatcherStatus(WatcherStatus{Watcher: s.kind.Name, Healthy: false, Revision: s.lastRevision(), Error: err})
+		select {
+		case <-time.After(watchRetryDelay):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// watchOnce runs a single watcher until it fails or the client is closed.
+func (s *Store) watchOnce() error {
+	c := s.client
+
+	var from uint64
+	if rev := s.lastRevision(); rev > 0 {
+		from = rev + 1
+		c.logger.Debug("Resuming watcher", "kind", s.kind.Name, "revision", from)
+	}
+	watcher, err := s.backend.Watch(c.ctx, from)
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+	c.reportWatcherStatus(WatcherStatus{Watcher: s.kind.Name, Healthy: true, Revision: s.lastRevision()})
+
+	for {
+		select {
+		case entry, ok := <-watcher.Updates():
+			if !ok {
+				return errWatcherClosed
 			}
-			select {
-			case <-time.After(2 * time.Second):
+			if entry == nil {
+				// End of the initial values: the cache has caught up.
+				s.markSynced()
+				c.reportWatcherStatus(WatcherStatus{Watcher: s.kind.Name, Healthy: true, Synced: true, Revision: s.lastRevision()})
 				continue
-			case <-c.ctx.Done():
-				return
 			}
+			s.apply(entry)
+
+		case <-c.ctx.Done():
+			return nil
 		}
-		select {
-		case c.watcherStatusCh <- WatcherStatus{Watcher: "servers", Healthy: true, Error: nil}:
-		default:
+	}
+}
+
+// lastRevision returns the last bucket revision applied by the watcher.
+func (s *Store) lastRevision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revision
+}
+
+// apply updates the cache from a watched entry and publishes the change.
+func (s *Store) apply(entry *Entry) {
+	key := entry.Key
+
+	if op := entry.Operation; op == EntryDelete || op == EntryPurge {
+		s.mu.Lock()
+		if entry.Revision <= s.revision {
+			s.mu.Unlock()
+			return
 		}
-		defer watcher.Stop()
-
-		for {
-			select {
-			case entry := <-watcher.Updates():
-				if entry == nil {
-					continue
-				}
-
-				var oldValue *Metadata
-				name := entry.Key()
-				var changeType ChangeType
-
-				if entry.Operation() == nats.KeyValueDelete {
-					c.serversMu.RLock()
-					if oldServer, exists := c.serversCache[name]; exists {
-						oldValue = oldServer
-					}
-					c.serversMu.RUnlock()
-
-					c.serversMu.Lock()
-					delete(c.serversCache, name)
-					c.serversMu.Unlock()
-
-					changeType = ChangeTypeDelete
-					c.eventBus.Publish(ServerChangeEventKey, MetadataChangeEvent{Key: name, OldValue: oldValue, NewValue: nil, Type: changeType})
-					continue
-				}
-
-				var server Metadata
-				if err := json.Unmarshal(entry.Value(), &server); err != nil {
-					c.logger.Warn("Failed to unmarshal server metadata", "error", err)
-					continue
-				}
-
-				c.serversMu.RLock()
-				if oldServer, exists := c.serversCache[name]; exists {
-					oldValue = oldServer
-				}
-				c.serversMu.RUnlock()
-
-				c.serversMu.Lock()
-				c.serversCache[name] = &server
-				c.serversMu.Unlock()
-
-				changeType = ChangeTypePut
-				c.eventBus.Publish(ServerChangeEventKey, MetadataChangeEvent{Key: name, OldValue: oldValue, NewValue: &server, Type: changeType})
-
-			case <-c.ctx.Done():
-				return
-			}
+		s.advanceLocked(entry.Revision)
+		oldValue, existed := s.cache[key]
+		s.setLocked(key, nil)
+		s.mu.Unlock()
+
+		if !existed || !s.HasSynced() {
+			// Nothing to report for a key this client never had, or
+			// while the initial values are still loading.
+			return
 		}
+		s.publish(MetadataChangeEvent{Key: key, OldValue: oldValue.DeepCopy(), NewValue: nil, Type: ChangeTypeDelete, Revision: entry.Revision, Timestamp: entry.Created})
+		return
 	}
+
+	m, err := decodeEntry(entry)
+	if err != nil {
+		s.client.logger.Warn("Failed to unmarshal metadata", "kind", s.kind.Name, "key", key, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	if entry.Revision <= s.revision {
+		s.mu.Unlock()
+		return
+	}
+	s.advanceLocked(entry.Revision)
+	oldValue := s.cache[key]
+	s.setLocked(key, m)
+	s.mu.Unlock()
+
+	if !s.HasSynced() {
+		// Initial values fill the cache without events, like a warm-up.
+		return
+	}
+
+	s.publish(MetadataChangeEvent{Key: key, OldValue: oldValue.DeepCopy(), NewValue: m.DeepCopy(), Type: ChangeTypePut, Revision: m.Revision, Timestamp: m.UpdatedAt})
 }
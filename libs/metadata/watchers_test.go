@@ -0,0 +1,208 @@
+package metadata
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// droppableStorage wraps a Storage so tests can kill the running watchers, as
+// a lost NATS subscription would.
+type droppableStorage struct {
+	Storage
+
+	mu       sync.Mutex
+	watchers []Watcher
+	froms    []uint64
+}
+
+type droppableBackend struct {
+	Backend
+	storage *droppableStorage
+}
+
+func (s *droppableStorage) Open(ctx context.Context, cfg BucketConfig) (Backend, error) {
+	b, err := s.Storage.Open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &droppableBackend{Backend: b, storage: s}, nil
+}
+
+func (b *droppableBackend) Watch(ctx context.Context, fromRevision uint64) (Watcher, error) {
+	w, err := b.Backend.Watch(ctx, fromRevision)
+	if err != nil {
+		return nil, err
+	}
+	b.storage.mu.Lock()
+	defer b.storage.mu.Unlock()
+	if b.Bucket() == "servers" {
+		b.storage.watchers = append(b.storage.watchers, w)
+		b.storage.froms = append(b.storage.froms, fromRevision)
+	}
+	return w, nil
+}
+
+func (s *droppableStorage) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.watchers {
+		w.Stop()
+	}
+	s.watchers = nil
+}
+
+func waitForStatus(t *testing.T, c *Client, cond func(WatcherStatus) bool) WatcherStatus {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case st := <-c.WatcherStatusChan():
+			if cond(st) {
+				return st
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for watcher status")
+		}
+	}
+}
+
+func TestWatcherResumesAfterLoss(t *testing.T) {
+	defer func(d time.Duration) { watchRetryDelay = d }(watchRetryDelay)
+	watchRetryDelay = 200 * time.Millisecond
+
+	storage := &droppableStorage{Storage: NewMemoryStorage()}
+	cfg := &Config{PlayersBucket: "players", ServersBucket: "servers", ReconcileBuckets: true}
+	c, err := NewClientWithStorage(context.Background(), cfg, storage, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewClientWithStorage failed: %v", err)
+	}
+	defer c.Close()
+
+	isServersSynced := func(st WatcherStatus) bool { return st.Watcher == KindServers && st.Synced }
+	waitForStatus(t, c, isServersSynced)
+
+	events := make(chan MetadataChangeEvent, 16)
+	unsub := c.SubscribeToServerChanges(func(e MetadataChangeEvent) { events <- e })
+	defer unsub()
+
+	if err := c.UpdateServer("a", func(m *Metadata) { m.SetLabel("x", "1") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	if e := <-events; e.Key != "a" {
+		t.Fatalf("expected event for a, got %+v", e)
+	}
+
+	storage.drop()
+	waitForStatus(t, c, func(st WatcherStatus) bool { return st.Watcher == KindServers && !st.Healthy })
+	if err := c.UpdateServer("b", func(m *Metadata) { m.SetLabel("x", "1") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	st := waitForStatus(t, c, isServersSynced)
+
+	select {
+	case e := <-events:
+		if e.Key != "b" || e.Type != ChangeTypePut {
+			t.Fatalf("expected only the missed put of b, got %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for missed event")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected replayed event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	storage.mu.Lock()
+	froms := append([]uint64(nil), storage.froms...)
+	storage.mu.Unlock()
+	if len(froms) != 2 || froms[0] != 0 || froms[1] == 0 {
+		t.Fatalf("expected a full watch then a resumed one, got from revisions %v", froms)
+	}
+	if b, ok := c.GetServer("b"); !ok || st.Revision != b.Revision {
+		t.Fatalf("expected synced revision %d to match b, got %+v", st.Revision, b)
+	}
+}
+
+func TestClientSyncsFromInitialValues(t *testing.T) {
+	storage := NewMemoryStorage()
+	servers, _ := storage.Open(context.Background(), BucketConfig{Bucket: "servers", Reconcile: true})
+	for _, name := range []string{"a", "b"} {
+		if _, err := servers.Put(context.Background(), name, []byte(`{"labels":{"mode":"lobby"}}`)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := servers.Delete(context.Background(), "b", 0); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	cfg := &Config{PlayersBucket: "players", ServersBucket: "servers", ReconcileBuckets: true}
+	c, err := NewClientWithStorage(context.Background(), cfg, storage, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewClientWithStorage failed: %v", err)
+	}
+	defer c.Close()
+
+	events := make(chan MetadataChangeEvent, 16)
+	unsub := c.SubscribeToServerChanges(func(e MetadataChangeEvent) { events <- e })
+	defer unsub()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.WaitForSync(ctx); err != nil {
+		t.Fatalf("WaitForSync failed: %v", err)
+	}
+	if !c.HasSynced() || !c.Servers().HasSynced() {
+		t.Fatalf("expected client to report synced")
+	}
+	if got := c.GetAllServers(); len(got) != 1 || got["a"] == nil {
+		t.Fatalf("expected only a in the cache, got %v", got)
+	}
+
+	select {
+	case e := <-events:
+		t.Fatalf("initial values should not publish events, got %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWaitForSyncHonoursContext(t *testing.T) {
+	s := newStore(&Client{}, ResourceKind{Name: KindServers}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.WaitForSync(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if s.HasSynced() {
+		t.Fatalf("expected store not to be synced")
+	}
+}
+
+func TestUpdateAndWaitTimesOutWithoutWatcher(t *testing.T) {
+	defer func(d time.Duration) { watchRetryDelay = d }(watchRetryDelay)
+	watchRetryDelay = time.Hour
+
+	storage := &droppableStorage{Storage: NewMemoryStorage()}
+	cfg := &Config{PlayersBucket: "players", ServersBucket: "servers", ReconcileBuckets: true}
+	c, err := NewClientWithStorage(context.Background(), cfg, storage, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewClientWithStorage failed: %v", err)
+	}
+	defer c.Close()
+	waitForStatus(t, c, func(st WatcherStatus) bool { return st.Watcher == KindServers && st.Synced })
+
+	storage.drop()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.UpdateServerAndWait(ctx, "a", func(m *Metadata) { m.SetLabel("x", "1") }); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	// The write itself went through.
+	if _, err := c.Servers().backend.Get(context.Background(), "a"); err != nil {
+		t.Fatalf("expected the write to be stored, got %v", err)
+	}
+}
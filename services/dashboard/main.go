@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -15,20 +19,26 @@ import (
 	"github.com/bafbi/stellaroot/services/dashboard/templates"
 )
 
+// defaultRequestTimeout bounds API requests when REQUEST_TIMEOUT is not set.
+const defaultRequestTimeout = 10 * time.Second
+
 type DashboardServer struct {
 	metadataClient *metadata.Client
 	logger         *slog.Logger
 	router         *gin.Engine
+	requestTimeout time.Duration
 }
 
 // Use view models defined in the templates package for both JSON and fragments
 type PlayerViewModel = templates.PlayerViewModel
 type ServerViewModel = templates.ServerViewModel
+type HistoryEntryViewModel = templates.HistoryEntryViewModel
 
 func NewDashboardServer(metadataClient *metadata.Client, logger *slog.Logger) *DashboardServer {
 	ds := &DashboardServer{
 		metadataClient: metadataClient,
 		logger:         logger,
+		requestTimeout: defaultRequestTimeout,
 	}
 
 	ds.setupRouter()
@@ -48,14 +58,61 @@ func (ds *DashboardServer) setupRouter() {
 	ds.router.GET("/servers", ds.handleServersPage)
 	ds.router.GET("/servers/fragment", ds.handleServersFragment)
 
+	// Probes
+	ds.router.GET("/healthz", ds.handleHealthz)
+	ds.router.GET("/readyz", ds.handleReadyz)
+
 	// API routes
-	api := ds.router.Group("/api")
+	api := ds.router.Group("/api", ds.withRequestTimeout)
 	{
 		api.GET("/players", ds.handlePlayersAPI)
 		api.GET("/servers", ds.handleServersAPI)
+		// The update endpoints treat an empty value as a delete; PATCH does not.
 		api.POST("/players/:uuid/update", ds.handleUpdatePlayer)
 		api.POST("/servers/:name/update", ds.handleUpdateServer)
+		api.PATCH("/players/:uuid", ds.handlePatchPlayer)
+		api.PATCH("/servers/:name", ds.handlePatchServer)
+		api.DELETE("/players/:uuid", ds.handleDeletePlayer)
+		api.DELETE("/servers/:name", ds.handleDeleteServer)
+		api.GET("/players/:uuid/history", ds.handlePlayerHistory)
+		api.GET("/servers/:name/history", ds.handleServerHistory)
+	}
+}
+
+// withRequestTimeout puts a deadline on the request context so metadata calls
+// give up instead of hanging when JetStream is degraded.
+func (ds *DashboardServer) withRequestTimeout(c *gin.Context) {
+	if ds.requestTimeout <= 0 {
+		c.Next()
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), ds.requestTimeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+func (ds *DashboardServer) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz reports ready only once the metadata cache has synced, so the
+// dashboard never serves partial lists.
+func (ds *DashboardServer) handleReadyz(c *gin.Context) {
+	if !ds.requireSynced(c) {
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// requireSynced writes a 503 and returns false while the metadata cache is
+// still loading.
+func (ds *DashboardServer) requireSynced(c *gin.Context) bool {
+	if ds.metadataClient.HasSynced() {
+		return true
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "metadata cache is not synced yet"})
+	return false
 }
 
 func (ds *DashboardServer) handleHome(c *gin.Context) {
@@ -72,29 +129,14 @@ func (ds *DashboardServer) handlePlayersPage(c *gin.Context) {
 }
 
 func (ds *DashboardServer) handlePlayersFragment(c *gin.Context) {
+	if !ds.requireSynced(c) {
+		return
+	}
 	// Build view models from current cache
 	players := ds.metadataClient.GetPlayersByLabels(map[string]string{})
 	var viewModels []PlayerViewModel
 	for uuid, player := range players {
-		name := "Unknown"
-		if player.Annotations != nil {
-			if playerName, ok := player.Annotations[string(constant.PlayerName)]; ok {
-				name = playerName
-			}
-		}
-		status := "Offline"
-		if player.Annotations != nil {
-			if online, ok := player.Annotations["online"]; ok && online == "true" {
-				status = "Online"
-			}
-		}
-		viewModels = append(viewModels, PlayerViewModel{
-			UUID:        uuid,
-			Name:        name,
-			Labels:      player.Labels,
-			Annotations: player.Annotations,
-			Status:      status,
-		})
+		viewModels = append(viewModels, newPlayerViewModel(uuid, player))
 	}
 	templates.PlayersFragment(viewModels).Render(c.Request.Context(), c.Writer)
 }
@@ -105,92 +147,113 @@ func (ds *DashboardServer) handleServersPage(c *gin.Context) {
 }
 
 func (ds *DashboardServer) handleServersFragment(c *gin.Context) {
+	if !ds.requireSynced(c) {
+		return
+	}
 	servers := ds.metadataClient.GetAllServers()
 	var viewModels []ServerViewModel
 	for name, server := range servers {
-		status := "Unknown"
-		playerCount := 0
-		if server.Annotations != nil {
-			if serverStatus, ok := server.Annotations["status"]; ok {
-				status = serverStatus
-			}
-			if playerCountStr, ok := server.Annotations["current_players"]; ok {
-				if count, err := strconv.Atoi(playerCountStr); err == nil {
-					playerCount = count
-				}
-			}
-		}
-		viewModels = append(viewModels, ServerViewModel{
-			Name:        name,
-			Labels:      server.Labels,
-			Annotations: server.Annotations,
-			Status:      status,
-			PlayerCount: playerCount,
-		})
+		viewModels = append(viewModels, ds.newServerViewModel(name, server))
 	}
 	templates.ServersFragment(viewModels).Render(c.Request.Context(), c.Writer)
 }
 
 func (ds *DashboardServer) handlePlayersAPI(c *gin.Context) {
-	players := ds.metadataClient.GetPlayersByLabels(map[string]string{})
+	if !ds.requireSynced(c) {
+		return
+	}
+	selector, err := metadata.ParseSelector(c.Query("selector"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	players := ds.metadataClient.SelectPlayers(selector)
 
 	var viewModels []PlayerViewModel
 	for uuid, player := range players {
-		name := "Unknown"
-		if player.Annotations != nil {
-			if playerName, ok := player.Annotations[string(constant.PlayerName)]; ok {
-				name = playerName
-			}
-		}
-
-		status := "Offline"
-		if player.Annotations != nil {
-			if online, ok := player.Annotations["online"]; ok && online == "true" {
-				status = "Online"
-			}
-		}
-
-		viewModels = append(viewModels, PlayerViewModel{
-			UUID:        uuid,
-			Name:        name,
-			Labels:      player.Labels,
-			Annotations: player.Annotations,
-			Status:      status,
-		})
+		viewModels = append(viewModels, newPlayerViewModel(uuid, player))
 	}
 
 	c.JSON(http.StatusOK, viewModels)
 }
 
 func (ds *DashboardServer) handleServersAPI(c *gin.Context) {
-	servers := ds.metadataClient.GetAllServers()
+	if !ds.requireSynced(c) {
+		return
+	}
+	selector, err := metadata.ParseSelector(c.Query("selector"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	servers := ds.metadataClient.SelectServers(selector)
 
 	var viewModels []ServerViewModel
 	for name, server := range servers {
-		status := "Unknown"
-		playerCount := 0
+		viewModels = append(viewModels, ds.newServerViewModel(name, server))
+	}
 
-		if server.Annotations != nil {
-			if serverStatus, ok := server.Annotations["status"]; ok {
-				status = serverStatus
-			}
-			if playerCountStr, ok := server.Annotations["current_players"]; ok {
-				if count, err := strconv.Atoi(playerCountStr); err == nil {
-					playerCount = count
-				}
-			}
+	c.JSON(http.StatusOK, viewModels)
+}
+
+func newPlayerViewModel(uuid string, player *metadata.Metadata) PlayerViewModel {
+	name := "Unknown"
+	if player.Annotations != nil {
+		if playerName, ok := player.Annotations[string(constant.PlayerUsername)]; ok {
+			name = playerName
+		}
+	}
+
+	status := "Offline"
+	if player.Annotations != nil {
+		if online, ok := player.Annotations["online"]; ok && online == "true" {
+			status = "Online"
 		}
+	}
 
-		viewModels = append(viewModels, ServerViewModel{
-			Name:        name,
-			Labels:      server.Labels,
-			Annotations: server.Annotations,
-			Status:      status,
-			PlayerCount: playerCount,
+	return PlayerViewModel{
+		UUID:        uuid,
+		Name:        name,
+		Labels:      player.Labels,
+		Annotations: player.Annotations,
+		Status:      status,
+		Revision:    player.Revision,
+		CreatedAt:   player.CreatedAt,
+		UpdatedAt:   player.UpdatedAt,
+	}
+}
+
+func (ds *DashboardServer) newServerViewModel(name string, server *metadata.Metadata) ServerViewModel {
+	status := "Unknown"
+	if state, ok := metadata.ServerState(server); ok {
+		status = string(state)
+	}
+
+	lastHeartbeat, _ := ds.metadataClient.LastHeartbeat(name)
+
+	conditions := make([]templates.ConditionViewModel, 0, len(server.Status.Conditions))
+	for _, cond := range server.Status.Conditions {
+		conditions = append(conditions, templates.ConditionViewModel{
+			Type:               cond.Type,
+			Status:             string(cond.Status),
+			Reason:             cond.Reason,
+			Message:            cond.Message,
+			LastTransitionTime: cond.LastTransitionTime,
 		})
 	}
 
-	c.JSON(http.StatusOK, viewModels)
+	return ServerViewModel{
+		Name:          name,
+		Labels:        server.Labels,
+		Annotations:   server.Annotations,
+		Status:        status,
+		PlayerCount:   server.Status.CurrentPlayers,
+		Revision:      server.Revision,
+		CreatedAt:     server.CreatedAt,
+		UpdatedAt:     server.UpdatedAt,
+		LastHeartbeat: lastHeartbeat,
+		Conditions:    conditions,
+	}
 }
 
 func (ds *DashboardServer) handleUpdatePlayer(c *gin.Context) {
@@ -206,7 +269,7 @@ func (ds *DashboardServer) handleUpdatePlayer(c *gin.Context) {
 		return
 	}
 
-	err := ds.metadataClient.UpdatePlayer(uuid, func(m *metadata.Metadata) {
+	err := ds.metadataClient.UpdatePlayerContext(c.Request.Context(), uuid, func(m *metadata.Metadata) {
 		// Update labels
 		for key, value := range updateData.Labels {
 			if value == "" {
@@ -219,14 +282,14 @@ func (ds *DashboardServer) handleUpdatePlayer(c *gin.Context) {
 		// Update annotations
 		for key, value := range updateData.Annotations {
 			if value == "" {
-				m.DeleteAnnotation(key)
+				m.DeleteAnnotation(constant.AnnotationKey(key))
 			} else {
-				m.SetAnnotation(key, value)
+				m.SetAnnotation(constant.AnnotationKey(key), value)
 			}
 		}
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ds.writeMetadataError(c, err)
 		return
 	}
 
@@ -246,7 +309,7 @@ func (ds *DashboardServer) handleUpdateServer(c *gin.Context) {
 		return
 	}
 
-	err := ds.metadataClient.UpdateServer(name, func(m *metadata.Metadata) {
+	err := ds.metadataClient.UpdateServerContext(c.Request.Context(), name, func(m *metadata.Metadata) {
 		// Update labels
 		for key, value := range updateData.Labels {
 			if value == "" {
@@ -259,20 +322,283 @@ func (ds *DashboardServer) handleUpdateServer(c *gin.Context) {
 		// Update annotations
 		for key, value := range updateData.Annotations {
 			if value == "" {
-				m.DeleteAnnotation(key)
+				m.DeleteAnnotation(constant.AnnotationKey(key))
 			} else {
-				m.SetAnnotation(key, value)
+				m.SetAnnotation(constant.AnnotationKey(key), value)
 			}
 		}
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ds.writeMetadataError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Server updated successfully"})
 }
 
+func (ds *DashboardServer) handlePatchPlayer(c *gin.Context) {
+	uuid := c.Param("uuid")
+
+	patchType, patch, opts, ok := readPatchRequest(c)
+	if !ok {
+		return
+	}
+
+	player, err := ds.metadataClient.PatchPlayer(c.Request.Context(), uuid, patchType, patch, opts...)
+	if err != nil {
+		ds.writeMetadataError(c, err)
+		return
+	}
+
+	c.Header("ETag", fmt.Sprintf(`"%d"`, player.Revision))
+	c.JSON(http.StatusOK, newPlayerViewModel(uuid, player))
+}
+
+func (ds *DashboardServer) handlePatchServer(c *gin.Context) {
+	name := c.Param("name")
+
+	patchType, patch, opts, ok := readPatchRequest(c)
+	if !ok {
+		return
+	}
+
+	server, err := ds.metadataClient.PatchServer(c.Request.Context(), name, patchType, patch, opts...)
+	if err != nil {
+		ds.writeMetadataError(c, err)
+		return
+	}
+
+	c.Header("ETag", fmt.Sprintf(`"%d"`, server.Revision))
+	c.JSON(http.StatusOK, ds.newServerViewModel(name, server))
+}
+
+// acceptPatch lists the patch formats accepted by the PATCH endpoints.
+var acceptPatch = metadata.MergePatchContentType + ", " + metadata.JSONPatchContentType
+
+// readPatchRequest picks the patch format from the Content-Type header and
+// reads the body and the expected revision. It writes the error response
+// itself when ok is false.
+func readPatchRequest(c *gin.Context) (patchType metadata.PatchType, patch []byte, opts []metadata.PatchOption, ok bool) {
+	c.Header("Accept-Patch", acceptPatch)
+
+	patchType, ok = metadata.PatchTypeForContentType(c.GetHeader("Content-Type"))
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + acceptPatch})
+		return 0, nil, nil, false
+	}
+
+	revision, err := revisionFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, nil, nil, false
+	}
+	if revision != 0 {
+		opts = append(opts, metadata.WithPatchRevision(revision))
+	}
+
+	patch, err = io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, nil, nil, false
+	}
+	return patchType, patch, opts, true
+}
+
+func (ds *DashboardServer) handleDeletePlayer(c *gin.Context) {
+	uuid := c.Param("uuid")
+
+	opts, policy, err := deleteOptionsFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ds.metadataClient.DeletePlayerContext(c.Request.Context(), uuid, opts...); err != nil {
+		ds.writeMetadataError(c, err)
+		return
+	}
+
+	if policy != metadata.DeleteBackground {
+		// The garbage collector finishes the deletion.
+		c.JSON(http.StatusAccepted, gin.H{"message": "Player deletion requested"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Player deleted successfully"})
+}
+
+func (ds *DashboardServer) handleDeleteServer(c *gin.Context) {
+	name := c.Param("name")
+
+	opts, policy, err := deleteOptionsFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ds.metadataClient.DeleteServerContext(c.Request.Context(), name, opts...); err != nil {
+		ds.writeMetadataError(c, err)
+		return
+	}
+
+	if policy != metadata.DeleteBackground {
+		// The garbage collector finishes the deletion.
+		c.JSON(http.StatusAccepted, gin.H{"message": "Server deletion requested"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Server deleted successfully"})
+}
+
+func (ds *DashboardServer) handlePlayerHistory(c *gin.Context) {
+	history, err := ds.metadataClient.PlayerHistory(c.Request.Context(), c.Param("uuid"))
+	if err != nil {
+		ds.writeMetadataError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newHistoryViewModels(history))
+}
+
+func (ds *DashboardServer) handleServerHistory(c *gin.Context) {
+	history, err := ds.metadataClient.ServerHistory(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		ds.writeMetadataError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newHistoryViewModels(history))
+}
+
+func newHistoryViewModels(history []metadata.HistoryEntry) []HistoryEntryViewModel {
+	viewModels := make([]HistoryEntryViewModel, 0, len(history))
+	for _, h := range history {
+		vm := HistoryEntryViewModel{
+			Revision:  h.Revision,
+			Type:      "put",
+			Timestamp: h.Timestamp,
+			ChangedBy: h.ChangedBy,
+			Changes:   []templates.FieldChangeViewModel{},
+		}
+		if h.Type == metadata.ChangeTypeDelete {
+			vm.Type = "delete"
+		}
+		for _, change := range h.Diff.Labels {
+			vm.Changes = append(vm.Changes, newFieldChangeViewModel("label", change))
+		}
+		for _, change := range h.Diff.Annotations {
+			vm.Changes = append(vm.Changes, newFieldChangeViewModel("annotation", change))
+		}
+		for _, change := range h.Diff.Conditions {
+			vm.Changes = append(vm.Changes, newFieldChangeViewModel("condition", change))
+		}
+		for _, change := range h.Diff.Owners {
+			vm.Changes = append(vm.Changes, newFieldChangeViewModel("owner", change))
+		}
+		viewModels = append(viewModels, vm)
+	}
+	return viewModels
+}
+
+func newFieldChangeViewModel(field string, change metadata.FieldChange) templates.FieldChangeViewModel {
+	return templates.FieldChangeViewModel{
+		Field:    field,
+		Key:      change.Key,
+		Change:   change.Type.String(),
+		OldValue: change.OldValue,
+		NewValue: change.NewValue,
+	}
+}
+
+// deleteOptionsFromRequest reads "?purge=true", "?cascade=" with a
+// propagation policy and the expected revision (see revisionFromRequest). It
+// also returns the policy, background unless given.
+func deleteOptionsFromRequest(c *gin.Context) ([]metadata.DeleteOption, metadata.PropagationPolicy, error) {
+	var opts []metadata.DeleteOption
+	policy := metadata.DeleteBackground
+
+	if purge := c.Query("purge"); purge != "" {
+		p, err := strconv.ParseBool(purge)
+		if err != nil {
+			return nil, policy, fmt.Errorf("invalid purge value %q", purge)
+		}
+		if p {
+			opts = append(opts, metadata.WithPurge())
+		}
+	}
+
+	if cascade := c.Query("cascade"); cascade != "" {
+		var err error
+		if policy, err = metadata.ParsePropagationPolicy(cascade); err != nil {
+			return nil, policy, err
+		}
+		opts = append(opts, metadata.WithPropagation(policy))
+	}
+
+	revision, err := revisionFromRequest(c)
+	if err != nil {
+		return nil, policy, err
+	}
+	if revision != 0 {
+		opts = append(opts, metadata.WithRevision(revision))
+	}
+
+	return opts, policy, nil
+}
+
+// revisionFromRequest reads the expected revision from either the If-Match
+// header or the "revision" query parameter. It returns 0 if there is none, or
+// for "*", which matches any revision.
+func revisionFromRequest(c *gin.Context) (uint64, error) {
+	revision := c.Query("revision")
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		// A weak ETag such as W/"5" still names a single revision.
+		revision = strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	}
+	if revision == "" || revision == "*" {
+		return 0, nil
+	}
+	rev, err := strconv.ParseUint(revision, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid revision %q", revision)
+	}
+	return rev, nil
+}
+
+func newFieldErrorViewModels(err *metadata.ValidationError) []templates.FieldErrorViewModel {
+	fields := make([]templates.FieldErrorViewModel, 0, len(err.Fields))
+	for _, f := range err.Fields {
+		fields = append(fields, templates.FieldErrorViewModel{
+			Field: "annotation",
+			Key:   string(f.Key),
+			Value: f.Value,
+			Error: f.Err.Error(),
+		})
+	}
+	return fields
+}
+
+// writeMetadataError maps metadata client errors to HTTP status codes.
+// Validation errors also list the rejected fields.
+func (ds *DashboardServer) writeMetadataError(c *gin.Context, err error) {
+	var validationErr *metadata.ValidationError
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, metadata.ErrConflict), errors.Is(err, metadata.ErrPatchFailed):
+		status = http.StatusConflict
+	case errors.Is(err, metadata.ErrInvalidPatch):
+		status = http.StatusUnprocessableEntity
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "fields": newFieldErrorViewModels(validationErr)})
+		return
+	case errors.Is(err, metadata.ErrAdmissionDenied):
+		status = http.StatusForbidden
+	case errors.Is(err, metadata.ErrAdmissionFailed):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
 func (ds *DashboardServer) Start(addr string) error {
 	ds.logger.Info("Starting dashboard server", "addr", addr)
 	return ds.router.Run(addr)
@@ -291,8 +617,27 @@ func main() {
 	}
 	defer metadataClient.Close()
 
+	if enabled, _ := strconv.ParseBool(os.Getenv("GARBAGE_COLLECTOR")); enabled {
+		// Every replica runs one; the lease lets a single one collect.
+		gc, err := metadataClient.StartGarbageCollector(context.Background(),
+			metadata.WithReferenceAnnotation(metadata.KindPlayers, constant.PlayerCurrentServer, metadata.KindServers))
+		if err != nil {
+			logger.Error("Failed to start garbage collector", "error", err)
+			os.Exit(1)
+		}
+		defer gc.Stop(context.Background())
+	}
+
 	// Create dashboard server
 	dashboardServer := NewDashboardServer(metadataClient, logger)
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Error("Invalid REQUEST_TIMEOUT", "value", v, "error", err)
+			os.Exit(1)
+		}
+		dashboardServer.requestTimeout = timeout
+	}
 
 	// Get port from environment or use default
 	port := os.Getenv("PORT")
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"github.com/bafbi/stellaroot/libs/metadata/metadatatest"
+	"github.com/bafbi/stellaroot/services/dashboard/templates"
+)
+
+func newTestServer(t *testing.T) (*DashboardServer, *metadata.Client) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	client := metadatatest.NewMemoryClient(t)
+	return NewDashboardServer(client, slog.New(slog.NewTextHandler(io.Discard, nil))), client
+}
+
+func serve(ds *DashboardServer, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	ds.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitForServer polls the client cache, which is filled asynchronously by the watcher.
+func waitForServer(t *testing.T, client *metadata.Client, name string, cond func(*metadata.Metadata) bool) *metadata.Metadata {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if m, ok := client.GetServer(name); ok && cond(m) {
+			return m
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for server %q", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServersAPISelector(t *testing.T) {
+	ds, client := newTestServer(t)
+
+	for name, mode := range map[string]string{"lobby-1": "lobby", "game-1": "game"} {
+		if err := client.UpdateServer(name, func(m *metadata.Metadata) { m.SetLabel("mode", mode) }); err != nil {
+			t.Fatalf("UpdateServer failed: %v", err)
+		}
+		waitForServer(t, client, name, func(*metadata.Metadata) bool { return true })
+	}
+
+	rec := serve(ds, http.MethodGet, "/api/servers?selector=mode%3Dlobby", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got []ServerViewModel
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "lobby-1" {
+		t.Fatalf("expected only lobby-1, got %+v", got)
+	}
+
+	if rec := serve(ds, http.MethodGet, "/api/servers?selector=mode+in+(", "", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid selector, got %d", rec.Code)
+	}
+}
+
+func TestUpdateServerAPI(t *testing.T) {
+	ds, client := newTestServer(t)
+
+	rec := serve(ds, http.MethodPost, "/api/servers/lobby-1/update", `{"labels":{"mode":"lobby"},"annotations":{"status":"running"}}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool { return m.HasLabel("mode", "lobby") })
+
+	// An empty value removes the label.
+	rec = serve(ds, http.MethodPost, "/api/servers/lobby-1/update", `{"labels":{"mode":""}}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool { return len(m.Labels) == 0 })
+
+	if rec := serve(ds, http.MethodPost, "/api/servers/lobby-1/update", `{`, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid body, got %d", rec.Code)
+	}
+}
+
+func TestPatchServerAPI(t *testing.T) {
+	ds, client := newTestServer(t)
+	mergePatch := http.Header{"Content-Type": {metadata.MergePatchContentType}}
+
+	rec := serve(ds, http.MethodPatch, "/api/servers/lobby-1", `{"labels":{"mode":"lobby"},"annotations":{"motd":""}}`, mergePatch)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got ServerViewModel
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got.Labels["mode"] != "lobby" || rec.Header().Get("ETag") != fmt.Sprintf(`"%d"`, got.Revision) {
+		t.Fatalf("unexpected response %+v, ETag %q", got, rec.Header().Get("ETag"))
+	}
+	m := waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool { return m.HasLabel("mode", "lobby") })
+	if motd, ok := m.Annotations["motd"]; !ok || motd != "" {
+		t.Fatalf("expected an empty motd annotation to be stored, got %v", m.Annotations)
+	}
+
+	jsonPatch := http.Header{"Content-Type": {metadata.JSONPatchContentType}}
+	rec = serve(ds, http.MethodPatch, "/api/servers/lobby-1", `[{"op":"test","path":"/labels/mode","value":"game"},{"op":"remove","path":"/labels/mode"}]`, jsonPatch)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a failed test, got %d: %s", rec.Code, rec.Body)
+	}
+
+	stale := http.Header{"Content-Type": jsonPatch["Content-Type"], "If-Match": {fmt.Sprintf(`"%d"`, got.Revision+1)}}
+	rec = serve(ds, http.MethodPatch, "/api/servers/lobby-1", `[{"op":"remove","path":"/labels/mode"}]`, stale)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a stale If-Match, got %d: %s", rec.Code, rec.Body)
+	}
+	weak := http.Header{"Content-Type": mergePatch["Content-Type"], "If-Match": {fmt.Sprintf(`W/"%d"`, got.Revision)}}
+	rec = serve(ds, http.MethodPatch, "/api/servers/lobby-1", `{"labels":{"mode":null}}`, weak)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a weak If-Match, got %d: %s", rec.Code, rec.Body)
+	}
+	waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool { return len(m.Labels) == 0 })
+	anyRevision := http.Header{"Content-Type": mergePatch["Content-Type"], "If-Match": {"*"}}
+	if rec := serve(ds, http.MethodPatch, "/api/servers/lobby-1", `{"labels":{"mode":"lobby"}}`, anyRevision); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for If-Match: *, got %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := serve(ds, http.MethodPatch, "/api/servers/lobby-1", `{"labels":{"mode":1}}`, mergePatch); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for an invalid patch, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = serve(ds, http.MethodPatch, "/api/servers/lobby-1", `{"labels":{}}`, nil)
+	if rec.Code != http.StatusUnsupportedMediaType || rec.Header().Get("Accept-Patch") == "" {
+		t.Fatalf("expected 415 with Accept-Patch for plain JSON, got %d", rec.Code)
+	}
+}
+
+func TestUpdateServerAPIValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := metadatatest.NewConfig("")
+	cfg.AnnotationValidation = metadata.ValidationReject
+	client := metadatatest.NewMemoryClientWithConfig(t, metadata.NewMemoryStorage(), cfg)
+	ds := NewDashboardServer(client, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, req := range []struct{ method, body string }{
+		{http.MethodPost, `{"annotations":{"player/online":"maybe"}}`},
+		{http.MethodPatch, `{"annotations":{"player/online":"maybe"}}`},
+	} {
+		path := "/api/players/8f0c2a4e-0000-4000-8000-000000000001"
+		header := http.Header{"Content-Type": {metadata.MergePatchContentType}}
+		if req.method == http.MethodPost {
+			path, header = path+"/update", nil
+		}
+		rec := serve(ds, req.method, path, req.body, header)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: expected 422, got %d: %s", req.method, rec.Code, rec.Body)
+		}
+		var got struct {
+			Fields []templates.FieldErrorViewModel `json:"fields"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(got.Fields) != 1 || got.Fields[0].Key != "player/online" || got.Fields[0].Value != "maybe" || got.Fields[0].Error == "" {
+			t.Fatalf("%s: unexpected field errors %+v", req.method, got.Fields)
+		}
+	}
+
+	if rec := serve(ds, http.MethodPost, "/api/players/8f0c2a4e-0000-4000-8000-000000000001/update", `{"annotations":{"player/online":"true"}}`, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a valid value, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDeleteServerAPI(t *testing.T) {
+	ds, client := newTestServer(t)
+
+	if rec := serve(ds, http.MethodDelete, "/api/servers/missing", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing server, got %d", rec.Code)
+	}
+
+	if err := client.UpdateServer("lobby-1", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	m := waitForServer(t, client, "lobby-1", func(*metadata.Metadata) bool { return true })
+
+	stale := http.Header{"If-Match": {fmt.Sprintf(`"%d"`, m.Revision+1)}}
+	if rec := serve(ds, http.MethodDelete, "/api/servers/lobby-1", "", stale); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for stale If-Match, got %d", rec.Code)
+	}
+
+	current := http.Header{"If-Match": {fmt.Sprintf(`"%d"`, m.Revision)}}
+	if rec := serve(ds, http.MethodDelete, "/api/servers/lobby-1", "", current); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(ds, http.MethodDelete, "/api/servers/lobby-1", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+func TestDeleteServerAPICascade(t *testing.T) {
+	ds, client := newTestServer(t)
+	if err := client.UpdateServer("lobby-1", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+
+	if rec := serve(ds, http.MethodDelete, "/api/servers/lobby-1?cascade=sometimes", "", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid cascade, got %d", rec.Code)
+	}
+	if rec := serve(ds, http.MethodDelete, "/api/servers/lobby-1?cascade=orphan", "", nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool {
+		return m.Deletion != nil && m.Deletion.Policy == metadata.DeleteOrphan
+	})
+}
+
+// unsyncedStorage never lets a watcher start, so the client stays unsynced.
+type unsyncedStorage struct{ metadata.Storage }
+
+type unsyncedBackend struct{ metadata.Backend }
+
+func (s unsyncedStorage) Open(ctx context.Context, cfg metadata.BucketConfig) (metadata.Backend, error) {
+	b, err := s.Storage.Open(ctx, cfg)
+	return unsyncedBackend{b}, err
+}
+
+func (unsyncedBackend) Watch(context.Context, uint64) (metadata.Watcher, error) {
+	return nil, errors.New("watch unavailable")
+}
+
+func TestNotReadyUntilSynced(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := metadata.NewClientWithStorage(context.Background(), metadatatest.NewConfig(""), unsyncedStorage{metadata.NewMemoryStorage()}, logger)
+	if err != nil {
+		t.Fatalf("NewClientWithStorage failed: %v", err)
+	}
+	defer client.Close()
+	ds := NewDashboardServer(client, logger)
+
+	for _, path := range []string{"/readyz", "/api/servers", "/api/players", "/servers/fragment"} {
+		if rec := serve(ds, http.MethodGet, path, "", nil); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s: expected 503 before sync, got %d", path, rec.Code)
+		}
+	}
+	if rec := serve(ds, http.MethodGet, "/healthz", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz: expected 200, got %d", rec.Code)
+	}
+
+	ds, _ = newTestServer(t)
+	if rec := serve(ds, http.MethodGet, "/readyz", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /readyz: expected 200 once synced, got %d", rec.Code)
+	}
+}
+
+// stalledStorage makes every write block until its context is done, like a
+// JetStream that stopped answering.
+type stalledStorage struct{ metadata.Storage }
+
+type stalledBackend struct{ metadata.Backend }
+
+func (s stalledStorage) Open(ctx context.Context, cfg metadata.BucketConfig) (metadata.Backend, error) {
+	b, err := s.Storage.Open(ctx, cfg)
+	return stalledBackend{b}, err
+}
+
+func (stalledBackend) Get(ctx context.Context, _ string) (*metadata.Entry, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRequestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := metadata.NewClientWithStorage(context.Background(), metadatatest.NewConfig(""), stalledStorage{metadata.NewMemoryStorage()}, logger)
+	if err != nil {
+		t.Fatalf("NewClientWithStorage failed: %v", err)
+	}
+	defer client.Close()
+	ds := NewDashboardServer(client, logger)
+	ds.requestTimeout = 20 * time.Millisecond
+
+	rec := serve(ds, http.MethodPost, "/api/servers/lobby-1/update", `{"labels":{"mode":"lobby"}}`, nil)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(ds, http.MethodDelete, "/api/servers/lobby-1", "", nil); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504 for delete, got %d", rec.Code)
+	}
+}
+
+func TestServersAPILastHeartbeat(t *testing.T) {
+	ds, client := newTestServer(t)
+
+	h, err := client.StartHeartbeat(context.Background(), "lobby-1", time.Second)
+	if err != nil {
+		t.Fatalf("StartHeartbeat failed: %v", err)
+	}
+	defer h.Stop(context.Background())
+	waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool {
+		_, ok := client.LastHeartbeat("lobby-1")
+		return ok && m.Status.IsConditionTrue(metadata.ConditionOnline)
+	})
+
+	rec := serve(ds, http.MethodGet, "/api/servers", "", nil)
+	var got []ServerViewModel
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(got) != 1 || got[0].Status != "online" || got[0].LastHeartbeat.IsZero() {
+		t.Fatalf("expected lobby-1 online with a heartbeat, got %+v", got)
+	}
+}
+
+func TestServersAPIConditions(t *testing.T) {
+	ds, client := newTestServer(t)
+
+	if err := client.UpdateServer("lobby-1", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	if _, err := client.UpdateServerStatus(context.Background(), "lobby-1", func(s *metadata.Status) {
+		s.SetCondition(metadata.Condition{Type: "Ready", Status: metadata.ConditionFalse, Reason: "WorldLoading", Message: "loading spawn"})
+	}); err != nil {
+		t.Fatalf("UpdateServerStatus failed: %v", err)
+	}
+	waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool { return len(m.Status.Conditions) == 1 })
+
+	rec := serve(ds, http.MethodGet, "/api/servers", "", nil)
+	var got []ServerViewModel
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(got) != 1 || len(got[0].Conditions) != 1 {
+		t.Fatalf("expected one server with one condition, got %+v", got)
+	}
+	if c := got[0].Conditions[0]; c.Type != "Ready" || c.Status != "False" || c.Reason != "WorldLoading" || c.LastTransitionTime.IsZero() {
+		t.Fatalf("unexpected condition %+v", c)
+	}
+
+	rec = serve(ds, http.MethodGet, "/servers/fragment", "", nil)
+	if !strings.Contains(rec.Body.String(), "Ready=False (WorldLoading): loading spawn") {
+		t.Fatalf("expected the fragment to render the condition, got %s", rec.Body)
+	}
+}
+
+func TestServersFragmentEditButton(t *testing.T) {
+	ds, client := newTestServer(t)
+	if err := client.UpdateServer("lobby-1", func(m *metadata.Metadata) { m.SetLabel("mode", "lobby") }); err != nil {
+		t.Fatalf("UpdateServer failed: %v", err)
+	}
+	m := waitForServer(t, client, "lobby-1", func(m *metadata.Metadata) bool { return m.HasLabel("mode", "lobby") })
+
+	// The edit form patches from the revision and values rendered here.
+	rec := serve(ds, http.MethodGet, "/servers/fragment", "", nil)
+	match := regexp.MustCompile(`data-server="([^"]*)"`).FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("expected an edit button with the server, got %s", rec.Body)
+	}
+	var got ServerViewModel
+	if err := json.Unmarshal([]byte(html.UnescapeString(match[1])), &got); err != nil {
+		t.Fatalf("invalid JSON in data-server: %v", err)
+	}
+	if got.Name != "lobby-1" || got.Revision != m.Revision || got.Labels["mode"] != "lobby" {
+		t.Fatalf("unexpected edit button data %+v", got)
+	}
+}
+
+func TestServerHistoryAPI(t *testing.T) {
+	ds, client := newTestServer(t)
+
+	for _, mode := range []string{"lobby", "game"} {
+		if err := client.UpdateServer("lobby-1", func(m *metadata.Metadata) { m.SetLabel("mode", mode) }); err != nil {
+			t.Fatalf("UpdateServer failed: %v", err)
+		}
+	}
+
+	rec := serve(ds, http.MethodGet, "/api/servers/lobby-1/history", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got []HistoryEntryViewModel
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(got) != 2 || got[1].Type != "put" || len(got[1].Changes) != 1 {
+		t.Fatalf("unexpected history %+v", got)
+	}
+	if c := got[1].Changes[0]; c.Field != "label" || c.Key != "mode" || c.Change != "changed" || c.OldValue != "lobby" || c.NewValue != "game" {
+		t.Fatalf("unexpected change %+v", c)
+	}
+
+	if rec := serve(ds, http.MethodGet, "/api/servers/missing/history", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown server, got %d", rec.Code)
+	}
+}
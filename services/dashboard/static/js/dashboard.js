@@ -33,17 +33,52 @@ function showToast(message, type = 'info') {
     }, 5000);
 }
 
+// Maps the field errors of a validation response to annotation keys.
+function fieldErrorsByKey(fields) {
+    const errors = {};
+    (fields || []).forEach(({key, error}) => { errors[key] = error; });
+    return errors;
+}
+
+// Builds the JSON Merge Patch turning original into the key/value rows of an
+// edit form: every row is set, even to an empty value, and keys removed from
+// the form are deleted with null.
+function mergePatchFor(original, rows) {
+    const patch = {};
+    Object.keys(original || {}).forEach((key) => { patch[key] = null; });
+    rows.forEach(({key, value}) => {
+        if (key.trim()) patch[key.trim()] = value;
+    });
+    return patch;
+}
+
+// Sends a merge patch, conditional on the revision the form was loaded from
+// when it is known.
+function sendMergePatch(url, revision, patch) {
+    const headers = { 'Content-Type': 'application/merge-patch+json' };
+    if (revision) {
+        headers['If-Match'] = `"${revision}"`;
+    }
+    return fetch(url, {
+        method: 'PATCH',
+        headers,
+        body: JSON.stringify(patch)
+    });
+}
+
 // Players data management
 function playersData() {
     return {
         players: [],
         loading: true,
         showEditModal: false,
+        fieldErrors: {},
         editingPlayer: {
             uuid: '',
             name: '',
             labels: [],
-            annotations: []
+            annotations: [],
+            original: {}
         },
         
         async loadPlayers() {
@@ -69,37 +104,32 @@ function playersData() {
                 uuid: player.uuid,
                 name: player.name || '',
                 labels: Object.entries(player.labels || {}).map(([key, value]) => ({key, value})),
-                annotations: Object.entries(player.annotations || {}).map(([key, value]) => ({key, value}))
+                annotations: Object.entries(player.annotations || {}).map(([key, value]) => ({key, value})),
+                original: player
             };
+            this.fieldErrors = {};
             this.showEditModal = true;
         },
         
         async savePlayer() {
-            const labels = {};
-            const annotations = {};
-            
-            this.editingPlayer.labels.forEach(({key, value}) => {
-                if (key.trim()) labels[key.trim()] = value;
-            });
-            
-            this.editingPlayer.annotations.forEach(({key, value}) => {
-                if (key.trim()) annotations[key.trim()] = value;
-            });
+            const original = this.editingPlayer.original;
+            const labels = mergePatchFor(original.labels, this.editingPlayer.labels);
+            const annotations = mergePatchFor(original.annotations, this.editingPlayer.annotations);
             
-            // Add player name to annotations (backend expects key "player/name")
+            // Add player name to annotations (backend expects key "player/username")
             if (this.editingPlayer.name) {
-                annotations['player/name'] = this.editingPlayer.name;
+                annotations['player/username'] = this.editingPlayer.name;
             }
             
             try {
-                const response = await fetch(`/api/players/${this.editingPlayer.uuid}/update`, {
-                    method: 'POST',
-                    headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ labels, annotations })
-                });
+                const response = await sendMergePatch(`/api/players/${this.editingPlayer.uuid}`,
+                    original.revision, { labels, annotations });
                 
                 const result = await response.json();
-                if (result.error) {
+                this.fieldErrors = fieldErrorsByKey(result.fields);
+                if (result.fields) {
+                    showToast('Some annotations are invalid', 'error');
+                } else if (result.error) {
                     showToast(result.error, 'error');
                 } else {
                     showToast('Player updated successfully', 'success');
@@ -129,10 +159,12 @@ function serversData() {
         servers: [],
         loading: true,
         showEditModal: false,
+        fieldErrors: {},
         editingServer: {
             name: '',
             labels: [],
-            annotations: []
+            annotations: [],
+            original: {}
         },
         
         async loadServers() {
@@ -157,32 +189,27 @@ function serversData() {
             this.editingServer = {
                 name: server.name,
                 labels: Object.entries(server.labels || {}).map(([key, value]) => ({key, value})),
-                annotations: Object.entries(server.annotations || {}).map(([key, value]) => ({key, value}))
+                annotations: Object.entries(server.annotations || {}).map(([key, value]) => ({key, value})),
+                original: server
             };
+            this.fieldErrors = {};
             this.showEditModal = true;
         },
         
         async saveServer() {
-            const labels = {};
-            const annotations = {};
-            
-            this.editingServer.labels.forEach(({key, value}) => {
-                if (key.trim()) labels[key.trim()] = value;
-            });
-            
-            this.editingServer.annotations.forEach(({key, value}) => {
-                if (key.trim()) annotations[key.trim()] = value;
-            });
+            const original = this.editingServer.original;
+            const labels = mergePatchFor(original.labels, this.editingServer.labels);
+            const annotations = mergePatchFor(original.annotations, this.editingServer.annotations);
             
             try {
-                const response = await fetch(`/api/servers/${this.editingServer.name}/update`, {
-                    method: 'POST',
-                    headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ labels, annotations })
-                });
+                const response = await sendMergePatch(`/api/servers/${this.editingServer.name}`,
+                    original.revision, { labels, annotations });
                 
                 const result = await response.json();
-                if (result.error) {
+                this.fieldErrors = fieldErrorsByKey(result.fields);
+                if (result.fields) {
+                    showToast('Some annotations are invalid', 'error');
+                } else if (result.error) {
                     showToast(result.error, 'error');
                 } else {
                     showToast('Server updated successfully', 'success');
@@ -1,5 +1,10 @@
 package templates
 
+import (
+    "fmt"
+    "time"
+)
+
 // PlayerViewModel is a presentation-friendly shape for player rows.
 type PlayerViewModel struct {
     UUID        string            `json:"uuid"`
@@ -7,13 +12,102 @@ type PlayerViewModel struct {
     Labels      map[string]string `json:"labels"`
     Annotations map[string]string `json:"annotations"`
     Status      string            `json:"status"`
+    Revision    uint64            `json:"revision"`
+    CreatedAt   time.Time         `json:"created_at,omitzero"`
+    UpdatedAt   time.Time         `json:"updated_at,omitzero"`
 }
 
 // ServerViewModel is a presentation-friendly shape for server rows.
 type ServerViewModel struct {
-    Name        string            `json:"name"`
-    Labels      map[string]string `json:"labels"`
-    Annotations map[string]string `json:"annotations"`
-    Status      string            `json:"status"`
-    PlayerCount int               `json:"player_count"`
+    Name          string               `json:"name"`
+    Labels        map[string]string    `json:"labels"`
+    Annotations   map[string]string    `json:"annotations"`
+    Status        string               `json:"status"`
+    PlayerCount   int                  `json:"player_count"`
+    Revision      uint64               `json:"revision"`
+    CreatedAt     time.Time            `json:"created_at,omitzero"`
+    UpdatedAt     time.Time            `json:"updated_at,omitzero"`
+    LastHeartbeat time.Time            `json:"last_heartbeat,omitzero"`
+    Conditions    []ConditionViewModel `json:"conditions"`
+}
+
+// ConditionViewModel is a status condition reported by a server.
+type ConditionViewModel struct {
+    Type               string    `json:"type"`
+    Status             string    `json:"status"` // "True", "False" or "Unknown"
+    Reason             string    `json:"reason,omitempty"`
+    Message            string    `json:"message,omitempty"`
+    LastTransitionTime time.Time `json:"last_transition_time,omitzero"`
+}
+
+// HistoryEntryViewModel is one revision in the history of a player or server.
+type HistoryEntryViewModel struct {
+    Revision  uint64                 `json:"revision"`
+    Type      string                 `json:"type"` // "put" or "delete"
+    Timestamp time.Time              `json:"timestamp"`
+    ChangedBy string                 `json:"changed_by,omitempty"`
+    Changes   []FieldChangeViewModel `json:"changes"`
+}
+
+// FieldChangeViewModel is a label, annotation, condition or owner change between revisions.
+type FieldChangeViewModel struct {
+    Field    string `json:"field"`  // "label", "annotation", "condition" or "owner"
+    Key      string `json:"key"`
+    Change   string `json:"change"` // "added", "removed" or "changed"
+    OldValue string `json:"old_value,omitempty"`
+    NewValue string `json:"new_value,omitempty"`
+}
+
+// FieldErrorViewModel is an annotation rejected by schema validation, shown
+// next to its field in the edit modals.
+type FieldErrorViewModel struct {
+    Field string `json:"field"` // "annotation"
+    Key   string `json:"key"`
+    Value string `json:"value"`
+    Error string `json:"error"`
+}
+
+// timeAgo renders a coarse "5m ago" style age for the tables.
+func timeAgo(t time.Time) string {
+    if t.IsZero() {
+        return "never"
+    }
+    d := time.Since(t)
+    switch {
+    case d < time.Minute:
+        return fmt.Sprintf("%ds ago", int(d.Seconds()))
+    case d < time.Hour:
+        return fmt.Sprintf("%dm ago", int(d.Minutes()))
+    case d < 24*time.Hour:
+        return fmt.Sprintf("%dh ago", int(d.Hours()))
+    default:
+        return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+    }
+}
+
+// conditionClass colours a condition badge by its status.
+func conditionClass(status string) string {
+    switch status {
+    case "True":
+        return "bg-green-100 text-green-800"
+    case "False":
+        return "bg-red-100 text-red-800"
+    default:
+        return "bg-gray-200 text-gray-800"
+    }
+}
+
+// conditionTitle is the tooltip of a condition badge.
+func conditionTitle(c ConditionViewModel) string {
+    title := c.Type + "=" + c.Status
+    if c.Reason != "" {
+        title += " (" + c.Reason + ")"
+    }
+    if c.Message != "" {
+        title += ": " + c.Message
+    }
+    if !c.LastTransitionTime.IsZero() {
+        title += ", since " + timeAgo(c.LastTransitionTime)
+    }
+    return title
 }
@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/bafbi/stellaroot/libs/constant"
 	"github.com/bafbi/stellaroot/libs/metadata"
 )
 
@@ -130,14 +131,16 @@ func seedServers(client *metadata.Client, logger *slog.Logger, opts options) []s
 		names = append(names, name)
 		region := regions[rand.Intn(len(regions))]
 		mode := modes[rand.Intn(len(modes))]
-		status := []string{"online", "offline"}[rand.Intn(2)]
+		online := rand.Intn(2) == 0
 		currentPlayers := rand.Intn(50)
 
 		_ = client.UpdateServer(name, func(m *metadata.Metadata) {
 			m.SetLabel("region", region)
 			m.SetLabel("game_mode", mode)
-			m.SetAnnotation("status", status)
-			m.SetAnnotation("current_players", fmt.Sprintf("%d", currentPlayers))
+		})
+		reportStatus(client, name, func(s *metadata.Status) {
+			setOnline(s, online)
+			s.CurrentPlayers = currentPlayers
 		})
 	}
 	logger.Info("seeded servers", "count", len(names))
@@ -162,11 +165,11 @@ func seedPlayers(client *metadata.Client, logger *slog.Logger, opts options, ser
 			m.SetLabel("tier", tier)
 			m.SetLabel("region", region)
 			// canonical player name annotation key
-			m.SetAnnotation(schema.S(schema.PlayerAnnotationKeyName), name)
+			m.SetAnnotation(constant.PlayerUsername, name)
 			// dashboard uses "online" annotation
 			m.SetAnnotation("online", fmt.Sprintf("%t", online))
 			if server != "" {
-				m.SetAnnotation("current_server", server)
+				m.SetAnnotation(constant.PlayerCurrentServer, server)
 			}
 		})
 	}
@@ -182,38 +185,33 @@ func randomServerUpdates(client *metadata.Client, serverNames []string) {
 	for i := 0; i < n; i++ {
 		name := serverNames[rand.Intn(len(serverNames))]
 		delta := rand.Intn(7) - 3 // -3..+3
-		_ = client.UpdateServer(name, func(m *metadata.Metadata) {
+		flip := rand.Intn(10) == 0
+		reportStatus(client, name, func(s *metadata.Status) {
 			// flip a coin for status
-			if rand.Intn(10) == 0 {
-				cur := strings.ToLower(m.Annotations["status"])
-				if cur == "online" {
-					m.SetAnnotation("status", "offline")
-				} else {
-					m.SetAnnotation("status", "online")
-				}
+			if flip {
+				setOnline(s, !s.IsConditionTrue(metadata.ConditionOnline))
 			}
 			// adjust player count within 0..100
-			cur := 0
-			if v, ok := m.GetLabel("max_players"); ok {
-				_ = v // not used, placeholder for future
-			}
-			if s, ok := m.GetAnnotation("current_players"); ok {
-				var x int
-				fmt.Sscanf(s, "%d", &x)
-				cur = x
-			}
-			cur += delta
-			if cur < 0 {
-				cur = 0
-			}
-			if cur > 100 {
-				cur = 100
-			}
-			m.SetAnnotation("current_players", fmt.Sprintf("%d", cur))
+			s.CurrentPlayers = min(max(s.CurrentPlayers+delta, 0), 100)
 		})
 	}
 }
 
+// reportStatus updates the status of a server the way a game server would,
+// through the status path that leaves its labels alone.
+func reportStatus(client *metadata.Client, name string, updateFunc func(*metadata.Status)) {
+	_, _ = client.UpdateServerStatus(context.Background(), name, updateFunc)
+}
+
+// setOnline sets the Online condition the heartbeat helpers would keep.
+func setOnline(s *metadata.Status, online bool) {
+	cond := metadata.Condition{Type: metadata.ConditionOnline, Status: metadata.ConditionTrue, Reason: metadata.ReasonHeartbeating}
+	if !online {
+		cond.Status, cond.Reason = metadata.ConditionFalse, metadata.ReasonStopped
+	}
+	s.SetCondition(cond)
+}
+
 func randomPlayerUpdates(client *metadata.Client, serverNames []string) {
 	// toggle a handful of random players online/offline by scanning cached map
 	players := client.GetPlayersByLabels(map[string]string{})
@@ -235,9 +233,9 @@ func randomPlayerUpdates(client *metadata.Client, serverNames []string) {
 			}
 			m.SetAnnotation("online", next)
 			if next == "true" && len(serverNames) > 0 {
-				m.SetAnnotation("current_server", serverNames[rand.Intn(len(serverNames))])
+				m.SetAnnotation(constant.PlayerCurrentServer, serverNames[rand.Intn(len(serverNames))])
 			} else {
-				m.DeleteAnnotation("current_server")
+				m.DeleteAnnotation(constant.PlayerCurrentServer)
 			}
 		})
 	}
@@ -337,6 +337,14 @@ func generateDescriptors(spec Spec, pkg, sourcePath string) string {
 		}
 	}
 
+	// Emit the list of every descriptor, used to validate annotations on write
+	fmt.Fprintf(&buf, "\n// AllAnnotationDescriptors lists the descriptor of every annotation above.\n")
+	fmt.Fprintf(&buf, "var AllAnnotationDescriptors = []%sAnnotationValidator{\n", qual)
+	for _, a := range anns {
+		fmt.Fprintf(&buf, "\t%sDesc,\n", a.Name)
+	}
+	fmt.Fprintf(&buf, "}\n")
+
 	return buf.String()
 }
 
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+)
+
+// fieldChanges is a parsed list of key=value (set) and key- (remove) arguments.
+type fieldChanges struct {
+	set    map[string]string
+	remove []string
+}
+
+func parseFieldChanges(args []string) (fieldChanges, error) {
+	changes := fieldChanges{set: make(map[string]string)}
+	for _, arg := range args {
+		if key, ok := strings.CutSuffix(arg, "-"); ok && !strings.Contains(arg, "=") {
+			if key == "" {
+				return changes, fmt.Errorf("invalid change %q", arg)
+			}
+			changes.remove = append(changes.remove, key)
+			continue
+		}
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return changes, fmt.Errorf("invalid change %q, want key=value or key-", arg)
+		}
+		changes.set[key] = value
+	}
+	if len(changes.set) == 0 && len(changes.remove) == 0 {
+		return changes, errors.New("no changes given, want key=value or key-")
+	}
+	return changes, nil
+}
+
+// conflict returns the first key whose existing value the changes would
+// replace, or "".
+func (fc fieldChanges) conflict(fields map[string]string) string {
+	for _, k := range slices.Sorted(maps.Keys(fc.set)) {
+		if old, ok := fields[k]; ok && old != fc.set[k] {
+			return k
+		}
+	}
+	return ""
+}
+
+// apply updates fields with the changes. Unless overwrite is set, changing an
+// existing value is refused and reported as the key that blocked it.
+func (fc fieldChanges) apply(fields map[string]string, overwrite bool) (conflict string) {
+	if !overwrite {
+		if conflict = fc.conflict(fields); conflict != "" {
+			return conflict
+		}
+	}
+	maps.Copy(fields, fc.set)
+	for _, k := range fc.remove {
+		delete(fields, k)
+	}
+	return ""
+}
+
+func runLabel(ctx context.Context, args []string) error {
+	return runFieldEdit(ctx, "label", args, func(m *metadata.Metadata) map[string]string { return m.Labels })
+}
+
+func runAnnotate(ctx context.Context, args []string) error {
+	return runFieldEdit(ctx, "annotate", args, func(m *metadata.Metadata) map[string]string { return m.Annotations })
+}
+
+// runFieldEdit implements label and annotate; fields picks the map to edit.
+func runFieldEdit(ctx context.Context, verb string, args []string, fields func(*metadata.Metadata) map[string]string) error {
+	fs := newFlagSet(verb, "players|servers (name | -l selector) key=value... key-...")
+	selector := fs.String("l", "", "edit every object matching this label selector instead of a name")
+	overwrite := fs.Bool("overwrite", false, "allow changing existing values")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 2 {
+		fs.Usage()
+		return errors.New("expected a resource type, a name or -l, and changes")
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	s, err := resolveStore(client, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForSync(ctx); err != nil {
+		return err
+	}
+	keys, rest, err := targetKeys(s, *selector, positional[1:])
+	if err != nil {
+		return err
+	}
+	changes, err := parseFieldChanges(rest)
+	if err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, key := range keys {
+		// Check the cached object first so a refused change writes nothing; the
+		// update re-checks against the latest revision.
+		var conflict string
+		var err error
+		if m, ok := s.Get(key); ok && !*overwrite {
+			conflict = changes.conflict(fields(m))
+		}
+		if conflict == "" {
+			err = s.UpdateContext(ctx, key, func(m *metadata.Metadata) {
+				conflict = changes.apply(fields(m), *overwrite)
+			})
+		}
+		switch {
+		case err != nil:
+			failed = true
+			fmt.Fprintf(os.Stderr, "%s/%s: %v\n", s.Kind().Name, key, err)
+		case conflict != "":
+			failed = true
+			fmt.Fprintf(os.Stderr, "%s/%s: %q already has a value, use -overwrite to change it\n", s.Kind().Name, key, conflict)
+		default:
+			fmt.Printf("%s/%s %s\n", s.Kind().Name, key, verbPast(verb))
+		}
+	}
+	if failed {
+		return errors.New("some objects were not changed")
+	}
+	return nil
+}
+
+func verbPast(verb string) string {
+	if verb == "annotate" {
+		return "annotated"
+	}
+	return verb + "ed"
+}
+
+// targetKeys resolves the objects a command acts on: every object matching
+// selector, or the name in args[0]. It returns the remaining arguments.
+func targetKeys(s *metadata.Store, selector string, args []string) (keys, rest []string, err error) {
+	if selector != "" {
+		sel, err := metadata.ParseSelector(selector)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = slices.Sorted(maps.Keys(s.Select(sel)))
+		if len(keys) == 0 {
+			return nil, nil, fmt.Errorf("no %s match %s", s.Kind().Name, sel)
+		}
+		return keys, args, nil
+	}
+	key, ok := resolveKey(s, args[0])
+	if !ok {
+		return nil, nil, fmt.Errorf("%s %q not found", s.Kind().Name, args[0])
+	}
+	return []string{key}, args[1:], nil
+}
+
+func runDelete(ctx context.Context, args []string) error {
+	fs := newFlagSet("delete", "players|servers (name... | -l selector) [-purge] [-cascade policy] [-dry-run]")
+	selector := fs.String("l", "", "delete every object matching this label selector")
+	purge := fs.Bool("purge", false, "drop the history as well")
+	cascade := fs.String("cascade", "background", "what happens to dependents: background, foreground or orphan")
+	dryRun := fs.Bool("dry-run", false, "print what would be deleted")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return errors.New("missing resource type")
+	}
+	if (*selector == "") == (len(positional) == 1) {
+		return errors.New("give either names or -l, not both or neither")
+	}
+	policy, err := metadata.ParsePropagationPolicy(*cascade)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	s, err := resolveStore(client, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForSync(ctx); err != nil {
+		return err
+	}
+
+	var keys []string
+	if *selector != "" {
+		if keys, _, err = targetKeys(s, *selector, nil); err != nil {
+			return err
+		}
+	}
+	for _, name := range positional[1:] {
+		key, ok := resolveKey(s, name)
+		if !ok {
+			return fmt.Errorf("%s %q not found", s.Kind().Name, name)
+		}
+		keys = append(keys, key)
+	}
+
+	opts := []metadata.DeleteOption{metadata.WithPropagation(policy)}
+	if *purge {
+		opts = append(opts, metadata.WithPurge())
+	}
+	// Foreground and orphan deletions are finished by the garbage collector.
+	deleted := "deleted"
+	if policy != metadata.DeleteBackground {
+		deleted = "marked for " + policy.String() + " deletion"
+	}
+	var failed bool
+	for _, key := range keys {
+		if *dryRun {
+			fmt.Printf("%s/%s %s (dry run)\n", s.Kind().Name, key, deleted)
+			continue
+		}
+		if err := s.DeleteContext(ctx, key, opts...); err != nil {
+			failed = true
+			fmt.Fprintf(os.Stderr, "%s/%s: %v\n", s.Kind().Name, key, err)
+			continue
+		}
+		fmt.Printf("%s/%s %s\n", s.Kind().Name, key, deleted)
+	}
+	if failed {
+		return errors.New("some objects were not deleted")
+	}
+	return nil
+}
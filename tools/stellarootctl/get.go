@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormats are the values accepted by -o.
+var outputFormats = []string{"table", "wide", "json", "yaml"}
+
+func runGet(ctx context.Context, args []string) error {
+	fs := newFlagSet("get", "players|servers [name...] [-l selector] [-o table|wide|json|yaml]")
+	selector := fs.String("l", "", "label selector, e.g. region=eu,tier!=free")
+	output := fs.String("o", "table", "output format: table, wide, json or yaml")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return errors.New("missing resource type")
+	}
+	if !slices.Contains(outputFormats, *output) {
+		return fmt.Errorf("invalid output format %q", *output)
+	}
+	sel, err := selectorFlag(*selector)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	s, err := resolveStore(client, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForSync(ctx); err != nil {
+		return err
+	}
+
+	var objects []object
+	names := positional[1:]
+	if len(names) == 0 {
+		matches := s.Select(sel)
+		for _, key := range slices.Sorted(maps.Keys(matches)) {
+			objects = append(objects, newObject(s, key, matches[key]))
+		}
+	}
+	for _, name := range names {
+		key, ok := resolveKey(s, name)
+		if !ok {
+			return fmt.Errorf("%s %q not found", s.Kind().Name, name)
+		}
+		m, _ := s.Get(key)
+		if sel.Matches(m.Labels) {
+			objects = append(objects, newObject(s, key, m))
+		}
+	}
+
+	// A single named object is printed on its own, a query as a list.
+	var single *object
+	if len(names) == 1 && len(objects) == 1 {
+		single = &objects[0]
+	}
+	return printObjects(os.Stdout, *output, s.Kind().Name, objects, single)
+}
+
+func printObjects(w io.Writer, format, kind string, objects []object, single *object) error {
+	if objects == nil {
+		objects = []object{}
+	}
+	var value any = struct {
+		Kind  string   `json:"kind" yaml:"kind"`
+		Items []object `json:"items" yaml:"items"`
+	}{kind, objects}
+	if single != nil {
+		value = single
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(value)
+	}
+
+	if len(objects) == 0 {
+		fmt.Fprintf(os.Stderr, "No %s found.\n", kind)
+		return nil
+	}
+	wide := format == "wide"
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	header := []string{"KEY"}
+	if kind == metadata.KindPlayers {
+		header = append(header, "NAME")
+	}
+	if kind == metadata.KindServers {
+		header = append(header, "STATUS")
+	}
+	header = append(header, "REVISION", "AGE")
+	if wide {
+		header = append(header, "LABELS", "ANNOTATIONS")
+	}
+	printRow(tw, header)
+	for _, o := range objects {
+		row := []string{o.Key}
+		if kind == metadata.KindPlayers {
+			row = append(row, orNone(o.Name))
+		}
+		if kind == metadata.KindServers {
+			row = append(row, orNone(o.State))
+		}
+		row = append(row, fmt.Sprint(o.Revision), age(o.CreatedAt))
+		if wide {
+			row = append(row, formatMap(o.Labels), fmt.Sprint(len(o.Annotations)))
+		}
+		printRow(tw, row)
+	}
+	return tw.Flush()
+}
+
+func printRow(w io.Writer, columns []string) {
+	for i, c := range columns {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, c)
+	}
+	fmt.Fprintln(w)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+func runDescribe(ctx context.Context, args []string) error {
+	fs := newFlagSet("describe", "players|servers name [-history n]")
+	history := fs.Int("history", 5, "number of recent revisions to show")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return errors.New("expected a resource type and a name")
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	s, err := resolveStore(client, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForSync(ctx); err != nil {
+		return err
+	}
+	key, ok := resolveKey(s, positional[1])
+	if !ok {
+		return fmt.Errorf("%s %q not found", s.Kind().Name, positional[1])
+	}
+	m, _ := s.Get(key)
+	o := newObject(s, key, m)
+
+	w := os.Stdout
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Kind:\t%s\n", o.Kind)
+	fmt.Fprintf(tw, "Key:\t%s\n", o.Key)
+	if o.Name != "" {
+		fmt.Fprintf(tw, "Name:\t%s\n", o.Name)
+	}
+	fmt.Fprintf(tw, "Revision:\t%d\n", o.Revision)
+	fmt.Fprintf(tw, "Generation:\t%d\n", o.Generation)
+	fmt.Fprintf(tw, "Created:\t%s (%s ago)\n", o.CreatedAt.Format(timeFormat), age(o.CreatedAt))
+	fmt.Fprintf(tw, "Updated:\t%s (%s ago)\n", o.UpdatedAt.Format(timeFormat), age(o.UpdatedAt))
+	fmt.Fprintf(tw, "Owners:\t%s\n", orNone(strings.Join(o.Owners, ",")))
+	if o.Status != nil && o.Status.CurrentPlayers != 0 {
+		fmt.Fprintf(tw, "Players:\t%d\n", o.Status.CurrentPlayers)
+	}
+	if d := o.Deletion; d != nil {
+		fmt.Fprintf(tw, "Deleting:\t%s, requested %s ago\n", d.Policy, age(d.RequestedAt))
+	}
+	tw.Flush()
+	printSection(w, "Labels", o.Labels)
+	printSection(w, "Annotations", o.Annotations)
+	printConditions(w, o.Status)
+
+	if *history <= 0 {
+		return nil
+	}
+	entries, err := s.History(ctx, key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "History:")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	printRow(tw, []string{"  REVISION", "TYPE", "AGE", "CHANGED BY", "CHANGES"})
+	for _, h := range entries[max(0, len(entries)-*history):] {
+		printRow(tw, []string{"  " + fmt.Sprint(h.Revision), changeTypeName(h.Type), age(h.Timestamp), orNone(h.ChangedBy), formatDiff(h.Diff)})
+	}
+	return tw.Flush()
+}
+
+const timeFormat = "2006-01-02 15:04:05 MST"
+
+func printConditions(w io.Writer, status *objectStatus) {
+	if status == nil || len(status.Conditions) == 0 {
+		fmt.Fprintln(w, "Conditions: <none>")
+		return
+	}
+	if status.ObservedGeneration != 0 {
+		fmt.Fprintf(w, "Conditions (observed generation %d):\n", status.ObservedGeneration)
+	} else {
+		fmt.Fprintln(w, "Conditions:")
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	printRow(tw, []string{"  TYPE", "STATUS", "REASON", "AGE", "MESSAGE"})
+	for _, c := range status.Conditions {
+		printRow(tw, []string{"  " + c.Type, c.Status, orNone(c.Reason), age(c.LastTransitionTime), orNone(c.Message)})
+	}
+	tw.Flush()
+}
+
+func printSection(w io.Writer, title string, m map[string]string) {
+	fmt.Fprintf(w, "%s:", title)
+	if len(m) == 0 {
+		fmt.Fprintln(w, " <none>")
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		fmt.Fprintf(tw, "  %s:\t%s\n", k, m[k])
+	}
+	tw.Flush()
+}
+
+func changeTypeName(t metadata.ChangeType) string {
+	if t == metadata.ChangeTypeDelete {
+		return "delete"
+	}
+	return "put"
+}
+
+// formatDiff renders a diff as kubectl-label style changes: +k=v, -k,
+// k=old->new, and +owner=kind/key or -owner=kind/key for owner references.
+func formatDiff(d metadata.Diff) string {
+	var parts []string
+	for _, changes := range [][]metadata.FieldChange{d.Labels, d.Annotations, d.Conditions} {
+		for _, c := range changes {
+			switch c.Type {
+			case metadata.FieldAdded:
+				parts = append(parts, "+"+c.Key+"="+c.NewValue)
+			case metadata.FieldRemoved:
+				parts = append(parts, "-"+c.Key)
+			case metadata.FieldChanged:
+				parts = append(parts, c.Key+"="+c.OldValue+"->"+c.NewValue)
+			}
+		}
+	}
+	for _, c := range d.Owners {
+		if c.Type == metadata.FieldAdded {
+			parts = append(parts, "+owner="+c.Key)
+		} else {
+			parts = append(parts, "-owner="+c.Key)
+		}
+	}
+	if len(parts) == 0 {
+		return "<none>"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,148 @@
+// Command stellarootctl inspects and manages Stellaroot metadata from the
+// command line. It connects with the same environment variables as the
+// services (see metadata.NewConfigFromEnv).
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"get", "list players or servers", runGet},
+	{"describe", "show one object with its recent history", runDescribe},
+	{"label", "set or remove labels", runLabel},
+	{"annotate", "set or remove annotations", runAnnotate},
+	{"delete", "delete objects", runDelete},
+	{"watch", "stream changes", runWatch},
+	{"export", "write players and servers to a snapshot", runExport},
+	{"import", "apply a snapshot", runImport},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: stellarootctl <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'stellarootctl <command> -h' for the flags of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(ctx, args)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stellarootctl %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "stellarootctl: unknown command %q\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+// newFlagSet returns a flag set for a command that reports parse errors
+// instead of exiting.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: stellarootctl %s %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags placed anywhere among the positional arguments, as in
+// "get players -l tier=premium", and returns the positional ones. Everything
+// after "--" is positional.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// connect creates a metadata client from the environment. Writes are stamped
+// with METADATA_CHANGED_BY, or stellarootctl and the local user by default.
+// Buckets are left to the services: connecting fails if one is missing.
+func connect(ctx context.Context) (*metadata.Client, error) {
+	cfg := metadata.NewConfigFromEnv()
+	cfg.ReconcileBuckets = false
+	if cfg.ChangedBy == "" {
+		cfg.ChangedBy = "stellarootctl"
+		if user := os.Getenv("USER"); user != "" {
+			cfg.ChangedBy += ":" + user
+		}
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	client, err := metadata.NewClient(ctx, cfg, logger.With("component", "stellarootctl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s: %w", cfg.NATSUrl, err)
+	}
+	return client, nil
+}
+
+// kindsFlag parses a comma-separated list of resource kinds.
+func kindsFlag(s string) []string {
+	var kinds []string
+	for _, kind := range strings.Split(s, ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// selectorFlag parses the -l flag; an empty value selects everything.
+func selectorFlag(s string) (metadata.Selector, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return metadata.ParseSelector(s)
+}
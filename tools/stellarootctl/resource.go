@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+)
+
+// kindAliases maps the resource names accepted on the command line to kinds.
+var kindAliases = map[string]string{
+	"player":  metadata.KindPlayers,
+	"players": metadata.KindPlayers,
+	"p":       metadata.KindPlayers,
+	"server":  metadata.KindServers,
+	"servers": metadata.KindServers,
+	"s":       metadata.KindServers,
+}
+
+// resolveStore returns the store of the kind named on the command line.
+func resolveStore(client *metadata.Client, resource string) (*metadata.Store, error) {
+	kind, ok := kindAliases[strings.ToLower(resource)]
+	if !ok {
+		kind = resource
+	}
+	s, ok := client.Store(kind)
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %q, want players or servers", resource)
+	}
+	return s, nil
+}
+
+// resolveKey accepts either a key or, for kinds with a name annotation such
+// as players, a name.
+func resolveKey(s *metadata.Store, arg string) (string, bool) {
+	if _, ok := s.Get(arg); ok {
+		return arg, true
+	}
+	return s.KeyForName(arg)
+}
+
+// object is how commands print an object.
+type object struct {
+	Kind        string            `json:"kind" yaml:"kind"`
+	Key         string            `json:"key" yaml:"key"`
+	Name        string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Revision    uint64            `json:"revision" yaml:"revision"`
+	Generation  int64             `json:"generation,omitempty" yaml:"generation,omitempty"`
+	CreatedAt   time.Time         `json:"created_at,omitzero" yaml:"created_at,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at,omitzero" yaml:"updated_at,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Status      *objectStatus     `json:"status,omitempty" yaml:"status,omitempty"`
+	Owners      []string          `json:"owners,omitempty" yaml:"owners,omitempty"`
+	Deletion    *objectDeletion   `json:"deletion,omitempty" yaml:"deletion,omitempty"`
+
+	// State is the STATUS column of servers (see metadata.ServerState).
+	State string `json:"-" yaml:"-"`
+}
+
+type objectDeletion struct {
+	Policy      string    `json:"policy" yaml:"policy"`
+	Purge       bool      `json:"purge,omitempty" yaml:"purge,omitempty"`
+	RequestedAt time.Time `json:"requested_at" yaml:"requested_at"`
+}
+
+type objectStatus struct {
+	ObservedGeneration int64             `json:"observed_generation,omitempty" yaml:"observed_generation,omitempty"`
+	Conditions         []objectCondition `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	CurrentPlayers     int               `json:"current_players,omitempty" yaml:"current_players,omitempty"`
+}
+
+type objectCondition struct {
+	Type               string    `json:"type" yaml:"type"`
+	Status             string    `json:"status" yaml:"status"`
+	Reason             string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Message            string    `json:"message,omitempty" yaml:"message,omitempty"`
+	LastTransitionTime time.Time `json:"last_transition_time,omitzero" yaml:"last_transition_time,omitempty"`
+}
+
+func newObject(s *metadata.Store, key string, m *metadata.Metadata) object {
+	o := object{
+		Kind:        s.Kind().Name,
+		Key:         key,
+		Revision:    m.Revision,
+		Generation:  m.Generation,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+		Labels:      m.Labels,
+		Annotations: m.Annotations,
+	}
+	if ann := s.Kind().NameAnnotation; ann != "" {
+		o.Name, _ = m.GetAnnotation(ann)
+	}
+	if state, ok := metadata.ServerState(m); ok {
+		o.State = string(state)
+	}
+	if m.Status.ObservedGeneration != 0 || len(m.Status.Conditions) > 0 || m.Status.CurrentPlayers != 0 {
+		o.Status = &objectStatus{ObservedGeneration: m.Status.ObservedGeneration, CurrentPlayers: m.Status.CurrentPlayers}
+		for _, c := range m.Status.Conditions {
+			o.Status.Conditions = append(o.Status.Conditions, objectCondition{
+				Type:               c.Type,
+				Status:             string(c.Status),
+				Reason:             c.Reason,
+				Message:            c.Message,
+				LastTransitionTime: c.LastTransitionTime,
+			})
+		}
+	}
+	for _, owner := range m.OwnerReferences {
+		o.Owners = append(o.Owners, owner.String())
+	}
+	if d := m.Deletion; d != nil {
+		o.Deletion = &objectDeletion{Policy: d.Policy.String(), Purge: d.Purge, RequestedAt: d.RequestedAt}
+	}
+	return o
+}
+
+// formatMap renders labels or annotations as k=v pairs sorted by key.
+func formatMap(m map[string]string) string {
+	if len(m) == 0 {
+		return "<none>"
+	}
+	parts := make([]string, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		parts = append(parts, k+"="+m[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+// age formats how long ago t was, like kubectl: 42s, 5m, 3h, 12d.
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+)
+
+func runExport(ctx context.Context, args []string) error {
+	fs := newFlagSet("export", "[-o file] [-l selector] [-kinds players,servers]")
+	out := fs.String("o", "-", "output file, - for stdout")
+	selector := fs.String("l", "", "label selector, e.g. region=eu,tier!=free")
+	kinds := fs.String("kinds", "players,servers", "comma-separated kinds to export")
+	if rest, err := parseArgs(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments %q", rest)
+	}
+	sel, err := selectorFlag(*selector)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := client.ExportSnapshot(ctx, w, metadata.ExportOptions{Kinds: kindsFlag(*kinds), Selector: sel}); err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, args []string) error {
+	fs := newFlagSet("import", "[-f file] [-mode merge|replace] [-dry-run] [-l selector] [-kinds ...]")
+	in := fs.String("f", "-", "snapshot file, - for stdin")
+	mode := fs.String("mode", "merge", "merge into existing objects, or replace them and delete the rest")
+	dryRun := fs.Bool("dry-run", false, "print the changes without writing them")
+	selector := fs.String("l", "", "only import (and, with -mode replace, delete) objects matching this label selector")
+	kinds := fs.String("kinds", "", "comma-separated kinds to import, default all in the snapshot")
+	verbose := fs.Bool("v", false, "list unchanged objects too")
+	if rest, err := parseArgs(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments %q", rest)
+	}
+	importMode, err := metadata.ParseImportMode(*mode)
+	if err != nil {
+		return err
+	}
+	sel, err := selectorFlag(*selector)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	result, err := client.ImportSnapshot(ctx, r, metadata.ImportOptions{
+		Mode:     importMode,
+		DryRun:   *dryRun,
+		Kinds:    kindsFlag(*kinds),
+		Selector: sel,
+	})
+	if result != nil {
+		printImportResult(os.Stdout, result, *verbose)
+	}
+	return err
+}
+
+func printImportResult(w io.Writer, result *metadata.ImportResult, verbose bool) {
+	for _, c := range result.Changes {
+		if c.Action == metadata.ImportUnchanged && !verbose {
+			continue
+		}
+		fmt.Fprintf(w, "%s/%s %s\n", c.Kind, c.Key, c.Action)
+	}
+	suffix := ""
+	if result.DryRun {
+		suffix = " (dry run)"
+	}
+	fmt.Fprintf(w, "%d created, %d updated, %d deleted, %d unchanged%s\n",
+		result.Count(metadata.ImportCreated), result.Count(metadata.ImportUpdated),
+		result.Count(metadata.ImportDeleted), result.Count(metadata.ImportUnchanged), suffix)
+}
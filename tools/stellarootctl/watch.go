@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bafbi/stellaroot/libs/metadata"
+)
+
+// watchEvent is how watch prints a change with -o json.
+type watchEvent struct {
+	Type      string  `json:"type"`
+	Revision  uint64  `json:"revision"`
+	Timestamp string  `json:"timestamp"`
+	Object    *object `json:"object,omitempty"`
+	Key       string  `json:"key"`
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	fs := newFlagSet("watch", "players|servers [-l selector] [-o table|json]")
+	selector := fs.String("l", "", "only show changes of objects matching this label selector")
+	output := fs.String("o", "table", "output format: table or json (one event per line)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errors.New("expected a resource type")
+	}
+	if !slices.Contains([]string{"table", "json"}, *output) {
+		return fmt.Errorf("invalid output format %q", *output)
+	}
+	sel, err := selectorFlag(*selector)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	s, err := resolveStore(client, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForSync(ctx); err != nil {
+		return err
+	}
+
+	// Rows are printed as they come, so columns have fixed widths.
+	const row = "%-8s  %-6s  %-36s  %-8v  %s\n"
+	enc := json.NewEncoder(os.Stdout)
+	if *output == "table" {
+		fmt.Printf(row, "TIME", "EVENT", "KEY", "REVISION", "CHANGES")
+	}
+	// Blocking keeps every change; the watcher waits on a slow terminal.
+	unsubscribe := s.Subscribe(func(e metadata.MetadataChangeEvent) {
+		if *output == "json" {
+			event := watchEvent{Type: changeTypeName(e.Type), Revision: e.Revision, Timestamp: e.Timestamp.Format(time.RFC3339Nano), Key: e.Key}
+			if e.NewValue != nil {
+				o := newObject(s, e.Key, e.NewValue)
+				event.Object = &o
+			}
+			if err := enc.Encode(event); err != nil {
+				fmt.Fprintf(os.Stderr, "stellarootctl watch: %v\n", err)
+			}
+			return
+		}
+		diff := metadata.DiffMetadata(e.OldValue, e.NewValue)
+		fmt.Printf(row, e.Timestamp.Local().Format(time.TimeOnly), changeTypeName(e.Type), e.Key, e.Revision, formatDiff(diff))
+	}, metadata.WithSelector(sel), metadata.WithOverflow(metadata.OverflowBlock), metadata.WithSubscriptionName("stellarootctl"))
+	defer unsubscribe()
+
+	<-ctx.Done()
+	return nil
+}